@@ -1,42 +1,105 @@
 package config
 
 import (
-	"fmt"
 	"os"
 	"path/filepath"
-
-	"gopkg.in/yaml.v3"
 )
 
 // Config represents the templater configuration
 type Config struct {
-	Defaults struct {
-		OutputDir     string `yaml:"output_dir"`
-		WatchInterval string `yaml:"watch_interval"`
-		Backup        bool   `yaml:"backup"`
-		Language      string `yaml:"language"`
-	} `yaml:"defaults"`
+	// Include lists further config files merged beneath this one
+	Include  []string           `yaml:"include,omitempty"`
+	Defaults Defaults           `yaml:"defaults"`
+	Targets  []Target           `yaml:"targets,omitempty"`
+	Profiles map[string]Profile `yaml:"profiles,omitempty"`
+
+	// path is the project file the configuration was loaded from
+	path string
+	// profile is the name of the applied profile
+	profile string
+	// sources maps dotted keys to the file, profile or variable that set them
+	sources map[string]string
+}
+
+// Defaults holds the default settings for generation and watching
+type Defaults struct {
+	OutputDir     string `yaml:"output_dir"`
+	WatchInterval string `yaml:"watch_interval"`
+	WatchBackend  string `yaml:"watch_backend"`
+	Backup        bool   `yaml:"backup"`
+	Language      string `yaml:"language"`
+	PluginDir     string `yaml:"plugin_dir"`
+	PluginTimeout string `yaml:"plugin_timeout"`
+}
+
+// Profile holds settings applied over the configuration when the profile is selected
+type Profile struct {
+	Defaults Defaults `yaml:"defaults"`
+	Targets  []Target `yaml:"targets,omitempty"`
+}
+
+// Target describes a template → data → output mapping generated as part of the project
+type Target struct {
+	// Name identifies the target in output and errors
+	Name string `yaml:"name"`
+	// Template is a template path or glob pattern
+	Template string `yaml:"template"`
+	// Data is the base data file
+	Data string `yaml:"data"`
+	// Overlays are data files merged over Data in order, later files winning
+	Overlays []string `yaml:"overlays"`
+	// Vars are inline values merged over all data files
+	Vars map[string]string `yaml:"vars"`
+	// Output is the output file, or the output directory when Template is a glob
+	// or the template has an output directive
+	Output string `yaml:"output"`
+	// Funcs names additional template function sets to enable
+	Funcs []string `yaml:"funcs"`
+	// PostProcess names the post-processors applied to each output, in order
+	PostProcess []string `yaml:"post_process"`
+	// Format selects the built-in formatters applied to each output: auto, by output
+	// extension, or none
+	Format string `yaml:"format"`
+	// Header starts each output with a "Code generated ... DO NOT EDIT." header
+	// recording the template, data files and templater version
+	Header bool `yaml:"header"`
+	// Merge keeps hand edits to the outputs, merging them with regenerated content
+	Merge bool `yaml:"merge"`
+	// Hooks are commands run around the target's generation
+	Hooks Hooks `yaml:"hooks"`
+}
+
+// Hooks are shell commands run around a target's generation, in order, from the
+// configuration's base directory
+type Hooks struct {
+	// PreRender runs before rendering; a failing command skips the target
+	PreRender []string `yaml:"pre_render"`
+	// PostWrite runs after the outputs were written, e.g. go build or a dev server reload
+	PostWrite []string `yaml:"post_write"`
+	// OnError runs when rendering, writing or another hook failed
+	OnError []string `yaml:"on_error"`
 }
 
 // LoadConfig loads the configuration from .templater.yaml
 func LoadConfig() (*Config, error) {
-	// Look for config in current directory and parent directories
-	configPath := findConfigFile()
-	if configPath == "" {
-		return &Config{}, nil // Return empty config if no file found
-	}
+	return Load(LoadOptions{})
+}
 
-	data, err := os.ReadFile(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("error reading config file: %w", err)
-	}
+// Path returns the project file the configuration was loaded from, or "" if none was found
+func (c *Config) Path() string {
+	return c.path
+}
 
-	var config Config
-	if err := yaml.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("error parsing config file: %w", err)
+// BaseDir returns the directory that relative target paths are resolved against
+func (c *Config) BaseDir() string {
+	if c.path != "" {
+		return filepath.Dir(c.path)
 	}
-
-	return &config, nil
+	dir, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+	return dir
 }
 
 // findConfigFile looks for .templater.yaml in current and parent directories
@@ -78,6 +141,14 @@ func (c *Config) GetWatchInterval() string {
 	return "1s"
 }
 
+// GetWatchBackend returns how watch mode detects changes: auto, fsnotify or poll
+func (c *Config) GetWatchBackend() string {
+	if c.Defaults.WatchBackend != "" {
+		return c.Defaults.WatchBackend
+	}
+	return "auto"
+}
+
 // ShouldBackup returns whether files should be backed up before overwriting
 func (c *Config) ShouldBackup() bool {
 	return c.Defaults.Backup
@@ -91,6 +162,23 @@ func (c *Config) GetLanguage() string {
 	return "en"
 }
 
+// GetPluginDir returns the directory external plugins are loaded from, relative to
+// the configuration's base directory unless absolute
+func (c *Config) GetPluginDir() string {
+	if c.Defaults.PluginDir != "" {
+		return c.Defaults.PluginDir
+	}
+	return "plugins"
+}
+
+// GetPluginTimeout returns how long a call to an external plugin may take
+func (c *Config) GetPluginTimeout() string {
+	if c.Defaults.PluginTimeout != "" {
+		return c.Defaults.PluginTimeout
+	}
+	return "10s"
+}
+
 // GetBackup returns whether backups should be created
 func (c *Config) GetBackup() bool {
 	if c == nil {
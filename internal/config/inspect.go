@@ -0,0 +1,167 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// SourceDefault is the source reported for settings left at their built-in default
+const SourceDefault = "default"
+
+// Setting is a resolved configuration value and where it came from
+type Setting struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// Settings returns the effective configuration values with the source of each:
+// a config file path, "profile <name>", "env <VARIABLE>" or SourceDefault
+func (c *Config) Settings() []Setting {
+	settings := []Setting{
+		{Key: "defaults.output_dir", Value: c.GetOutputDir()},
+		{Key: "defaults.watch_interval", Value: c.GetWatchInterval()},
+		{Key: "defaults.watch_backend", Value: c.GetWatchBackend()},
+		{Key: "defaults.backup", Value: strconv.FormatBool(c.ShouldBackup())},
+		{Key: "defaults.language", Value: c.GetLanguage()},
+		{Key: "defaults.plugin_dir", Value: c.GetPluginDir()},
+		{Key: "defaults.plugin_timeout", Value: c.GetPluginTimeout()},
+	}
+	for i, target := range c.Targets {
+		name := target.Name
+		if name == "" {
+			name = target.Template
+		}
+		settings = append(settings, Setting{
+			Key:   fmt.Sprintf("targets[%d]", i),
+			Value: fmt.Sprintf("%s: %s -> %s", name, target.Template, target.Output),
+		})
+	}
+
+	for i := range settings {
+		key := settings[i].Key
+		if strings.HasPrefix(key, "targets[") {
+			key = "targets"
+		}
+		settings[i].Source = SourceDefault
+		if source, exists := c.sources[key]; exists {
+			settings[i].Source = source
+		}
+	}
+
+	return settings
+}
+
+// ValidatePaths checks that the files referenced by the targets exist, reporting every problem found.
+// Relative paths are resolved against BaseDir.
+func (c *Config) ValidatePaths() error {
+	var errs []error
+	resolve := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(c.BaseDir(), path)
+	}
+
+	if info, err := os.Stat(resolve(c.GetOutputDir())); err == nil && !info.IsDir() {
+		errs = append(errs, fmt.Errorf("defaults.output_dir: %s is not a directory", c.GetOutputDir()))
+	}
+
+	for i, target := range c.Targets {
+		field := fmt.Sprintf("targets[%d]", i)
+		if target.Name != "" {
+			field = fmt.Sprintf("targets[%d] (%s)", i, target.Name)
+		}
+
+		if target.Template != "" {
+			matches, err := filepath.Glob(resolve(target.Template))
+			switch {
+			case err != nil:
+				errs = append(errs, fmt.Errorf("%s: invalid template pattern %s: %w", field, target.Template, err))
+			case len(matches) == 0:
+				errs = append(errs, fmt.Errorf("%s: no templates match %s", field, target.Template))
+			}
+		}
+
+		for _, data := range append([]string{target.Data}, target.Overlays...) {
+			if data == "" {
+				continue
+			}
+			if _, err := os.Stat(resolve(data)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: data file %s: %w", field, data, err))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// starterConfig is the commented configuration written by WriteStarterConfig
+const starterConfig = `# Templater configuration
+# Settings can be overridden per profile (--profile or TEMPLATER_PROFILE)
+# and by TEMPLATER_* environment variables, e.g. TEMPLATER_OUTPUT_DIR.
+
+# Further config files merged beneath this one, relative to this file
+# include:
+#   - config/shared.yaml
+
+defaults:
+  # Directory outputs are written to when a target has no output
+  output_dir: "generated"
+  # How long watch mode waits for changes to settle, as a Go duration
+  watch_interval: "1s"
+  # How watch mode detects changes: auto, fsnotify, or poll for network filesystems
+  # and container mounts, which scans every watch_interval
+  watch_backend: "auto"
+  # Back up existing files before overwriting them; off unless set to true
+  backup: false
+  # Language for messages
+  language: "en"
+  # Directory of external plugin executables, relative to this file
+  plugin_dir: "plugins"
+  # How long a call to an external plugin may take before it is restarted
+  plugin_timeout: "10s"
+
+# Generation targets, regenerated by running "templater generate" without flags
+# targets:
+#   - name: models
+#     template: templates/models/*.tmpl
+#     data: data/models.yaml
+#     overlays: []
+#     vars: {}
+#     output: internal/models
+#     funcs: []
+#     post_process: []
+#     format: auto
+#     header: false
+#     merge: false
+#     hooks:
+#       pre_render: []
+#       post_write: ["go build ./..."]
+#       on_error: []
+
+# Named profiles applied over the settings above
+# profiles:
+#   prod:
+#     defaults:
+#       output_dir: "dist"
+`
+
+// WriteStarterConfig writes a commented starter configuration to path,
+// refusing to replace an existing file unless force is set
+func WriteStarterConfig(path string, force bool) error {
+	if !force {
+		if _, err := os.Stat(path); err == nil {
+			return fmt.Errorf("config file %s already exists", path)
+		}
+	}
+
+	if err := os.WriteFile(path, []byte(starterConfig), 0644); err != nil {
+		return fmt.Errorf("error writing config file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,333 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// EnvConfig names the environment variable holding an explicit config file path
+	EnvConfig = "TEMPLATER_CONFIG"
+	// EnvProfile names the environment variable selecting a profile
+	EnvProfile = "TEMPLATER_PROFILE"
+	// envPrefix prefixes the environment variables overriding default settings
+	envPrefix = "TEMPLATER_"
+)
+
+// LoadOptions controls how the configuration is located and resolved
+type LoadOptions struct {
+	// Path is an explicit project config file; when empty, TEMPLATER_CONFIG and
+	// then .templater.yaml discovery from the working directory are used
+	Path string
+	// Profile selects a named profile; when empty, TEMPLATER_PROFILE is used
+	Profile string
+	// SkipUserConfig disables merging the user-level config file
+	SkipUserConfig bool
+}
+
+// Load resolves the configuration from, lowest to highest precedence: the user-level
+// config file, the project config file and its includes, the selected profile and
+// TEMPLATER_* environment variables. The result is validated before it is returned.
+func Load(opts LoadOptions) (*Config, error) {
+	merged := map[string]interface{}{}
+	sources := map[string]string{}
+
+	if !opts.SkipUserConfig {
+		if userPath := userConfigFile(); userPath != "" {
+			layer, err := readLayer(userPath, map[string]bool{}, sources)
+			if err != nil {
+				return nil, err
+			}
+			mergeMaps(merged, layer)
+		}
+	}
+
+	projectPath, err := projectConfigFile(opts.Path)
+	if err != nil {
+		return nil, err
+	}
+	if projectPath != "" {
+		layer, err := readLayer(projectPath, map[string]bool{}, sources)
+		if err != nil {
+			return nil, err
+		}
+		mergeMaps(merged, layer)
+	}
+
+	profile := opts.Profile
+	if profile == "" {
+		profile = os.Getenv(EnvProfile)
+	}
+	if profile != "" {
+		profiles, _ := merged["profiles"].(map[string]interface{})
+		raw, exists := profiles[profile]
+		if !exists {
+			return nil, fmt.Errorf("unknown config profile: %s", profile)
+		}
+		overlay, _ := raw.(map[string]interface{})
+		mergeMaps(merged, overlay)
+		recordSources(sources, overlay, "", "profile "+profile)
+	}
+
+	config, err := decodeConfig(merged)
+	if err != nil {
+		return nil, err
+	}
+	config.path = projectPath
+	config.profile = profile
+	config.sources = sources
+
+	if err := config.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// ProfileName returns the name of the applied profile, or "" if none was selected
+func (c *Config) ProfileName() string {
+	return c.profile
+}
+
+// Validate checks the configuration for invalid values, reporting every problem found
+func (c *Config) Validate() error {
+	var errs []error
+
+	if _, err := c.GetWatchIntervalDuration(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := c.GetPluginTimeoutDuration(); err != nil {
+		errs = append(errs, err)
+	}
+
+	switch c.GetWatchBackend() {
+	case "auto", "fsnotify", "poll":
+	default:
+		errs = append(errs, fmt.Errorf("defaults.watch_backend: must be auto, fsnotify or poll, got %s", c.GetWatchBackend()))
+	}
+
+	names := make(map[string]bool)
+	for i, target := range c.Targets {
+		if target.Template == "" {
+			errs = append(errs, fmt.Errorf("targets[%d]: template is required", i))
+		}
+		switch target.Format {
+		case "", "auto", "none":
+		default:
+			errs = append(errs, fmt.Errorf("targets[%d]: format must be auto or none, got %s", i, target.Format))
+		}
+		if target.Name != "" {
+			if names[target.Name] {
+				errs = append(errs, fmt.Errorf("targets[%d]: duplicate target name %q", i, target.Name))
+			}
+			names[target.Name] = true
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// GetWatchIntervalDuration returns the configured watch interval as a duration
+func (c *Config) GetWatchIntervalDuration() (time.Duration, error) {
+	interval, err := time.ParseDuration(c.GetWatchInterval())
+	if err != nil {
+		return 0, fmt.Errorf("defaults.watch_interval: %w", err)
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("defaults.watch_interval: must be positive, got %s", c.GetWatchInterval())
+	}
+	return interval, nil
+}
+
+// GetPluginTimeoutDuration returns the configured plugin timeout as a duration
+func (c *Config) GetPluginTimeoutDuration() (time.Duration, error) {
+	timeout, err := time.ParseDuration(c.GetPluginTimeout())
+	if err != nil {
+		return 0, fmt.Errorf("defaults.plugin_timeout: %w", err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("defaults.plugin_timeout: must be positive, got %s", c.GetPluginTimeout())
+	}
+	return timeout, nil
+}
+
+// projectConfigFile returns the project config file to load, checking that an explicit one exists
+func projectConfigFile(explicit string) (string, error) {
+	if explicit == "" {
+		explicit = os.Getenv(EnvConfig)
+	}
+	if explicit == "" {
+		return findConfigFile(), nil
+	}
+
+	if _, err := os.Stat(explicit); err != nil {
+		return "", fmt.Errorf("error reading config file: %w", err)
+	}
+	return filepath.Abs(explicit)
+}
+
+// userConfigFile returns the user-level config file if it exists
+func userConfigFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+
+	path := filepath.Join(dir, "templater", "config.yaml")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// readLayer reads a config file and the files it includes into a generic map,
+// recording in sources which file set each value. Included files are merged
+// first so the including file takes precedence.
+func readLayer(path string, visiting map[string]bool, sources map[string]string) (map[string]interface{}, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config path: %w", err)
+	}
+	if visiting[absPath] {
+		return nil, fmt.Errorf("config include cycle at %s", path)
+	}
+	visiting[absPath] = true
+	defer delete(visiting, absPath)
+
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
+	}
+
+	// Decode strictly first so that unknown keys are reported with their line
+	var typed Config
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&typed); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	layer := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &layer); err != nil {
+		return nil, fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+	delete(layer, "include")
+
+	merged := map[string]interface{}{}
+	for _, include := range typed.Include {
+		if !filepath.IsAbs(include) {
+			include = filepath.Join(filepath.Dir(absPath), include)
+		}
+		included, err := readLayer(include, visiting, sources)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		mergeMaps(merged, included)
+	}
+	mergeMaps(merged, layer)
+	recordSources(sources, layer, "", absPath)
+
+	return merged, nil
+}
+
+// recordSources attributes every value in layer to source, keyed by dotted path such as "defaults.output_dir"
+func recordSources(sources map[string]string, layer map[string]interface{}, prefix, source string) {
+	for k, v := range layer {
+		key := prefix + k
+		if nested, ok := v.(map[string]interface{}); ok {
+			recordSources(sources, nested, key+".", source)
+			continue
+		}
+		sources[key] = source
+	}
+}
+
+// mergeMaps merges src into dst, recursing into nested maps; other values, including lists, are replaced
+func mergeMaps(dst, src map[string]interface{}) {
+	for k, v := range src {
+		srcMap, srcIsMap := v.(map[string]interface{})
+		dstMap, dstIsMap := dst[k].(map[string]interface{})
+		if srcIsMap && dstIsMap {
+			mergeMaps(dstMap, srcMap)
+			continue
+		}
+		if srcIsMap {
+			// Copy so that later merges never modify a layer's own maps
+			copied := map[string]interface{}{}
+			mergeMaps(copied, srcMap)
+			v = copied
+		}
+		dst[k] = v
+	}
+}
+
+// decodeConfig converts a merged generic map into a Config
+func decodeConfig(merged map[string]interface{}) (*Config, error) {
+	data, err := yaml.Marshal(merged)
+	if err != nil {
+		return nil, fmt.Errorf("error merging config files: %w", err)
+	}
+
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, fmt.Errorf("error parsing merged config: %w", err)
+	}
+	return &config, nil
+}
+
+// applyEnv overrides default settings from TEMPLATER_* environment variables,
+// named after their YAML keys, e.g. TEMPLATER_OUTPUT_DIR for defaults.output_dir.
+// Only the defaults settings can be overridden; targets and profiles are set in files.
+func (c *Config) applyEnv() error {
+	defaults := reflect.ValueOf(&c.Defaults).Elem()
+	for i := 0; i < defaults.NumField(); i++ {
+		env := envVarName(defaults.Type().Field(i))
+		value, exists := os.LookupEnv(env)
+		if !exists {
+			continue
+		}
+
+		if c.sources != nil {
+			key := "defaults." + strings.Split(defaults.Type().Field(i).Tag.Get("yaml"), ",")[0]
+			c.sources[key] = "env " + env
+		}
+
+		field := defaults.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Bool:
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %w", env, err)
+			}
+			field.SetBool(parsed)
+		default:
+			return fmt.Errorf("%s: setting of kind %s cannot be set from the environment", env, field.Kind())
+		}
+	}
+	return nil
+}
+
+// envVarName returns the environment variable overriding a Defaults field
+func envVarName(field reflect.StructField) string {
+	key := strings.Split(field.Tag.Get("yaml"), ",")[0]
+	return envPrefix + strings.ToUpper(key)
+}
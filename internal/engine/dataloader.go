@@ -0,0 +1,47 @@
+package engine
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DataLoader parses the content of a data file read from path into template data
+type DataLoader func(path string, content []byte) (map[string]string, error)
+
+// dataLoaders stores the data loaders registered for file extensions besides JSON and YAML
+var dataLoaders = struct {
+	sync.RWMutex
+	loaders map[string]DataLoader
+}{
+	loaders: make(map[string]DataLoader),
+}
+
+// RegisterDataLoader registers a data loader for files with the given extension, such
+// as ".toml", replacing any loader registered for it. The built-in JSON and YAML
+// parsing is used for other extensions.
+func RegisterDataLoader(ext string, loader DataLoader) {
+	dataLoaders.Lock()
+	dataLoaders.loaders[strings.ToLower(ext)] = loader
+	dataLoaders.Unlock()
+}
+
+// DataLoaderExtensions returns the extensions with a registered data loader
+func DataLoaderExtensions() []string {
+	dataLoaders.RLock()
+	defer dataLoaders.RUnlock()
+
+	exts := make([]string, 0, len(dataLoaders.loaders))
+	for ext := range dataLoaders.loaders {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	return exts
+}
+
+// dataLoader returns the data loader registered for ext, or nil
+func dataLoader(ext string) DataLoader {
+	dataLoaders.RLock()
+	defer dataLoaders.RUnlock()
+	return dataLoaders.loaders[ext]
+}
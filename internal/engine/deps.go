@@ -0,0 +1,169 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template/parse"
+)
+
+// TemplateReferences describes what a template relies on besides its data
+type TemplateReferences struct {
+	// DependsOn are the template's depends_on files, as resolved by ResolveTemplateDependencies
+	DependsOn []string
+	// Uses are the names invoked with {{template}} or {{block}}
+	Uses []string
+	// Defines are the names defined with {{define}} or {{block}}
+	Defines []string
+}
+
+// ResolveTemplateDependencies returns the template's depends_on files; relative
+// paths are resolved against the directory containing the template. A relative path
+// that only exists relative to the working directory, as depends_on paths were
+// resolved before, is resolved against that instead.
+func ResolveTemplateDependencies(templatePath string) ([]string, error) {
+	deps, err := GetTemplateDependencies(templatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved := make([]string, len(deps))
+	for i, dep := range deps {
+		if !filepath.IsAbs(dep) {
+			dep = resolveRelativeDependency(templatePath, dep)
+		}
+		resolved[i] = filepath.Clean(dep)
+	}
+	return resolved, nil
+}
+
+// resolveRelativeDependency returns dep relative to the template's directory, or to
+// the working directory if only that file exists
+func resolveRelativeDependency(templatePath, dep string) string {
+	fromTemplate := filepath.Join(filepath.Dir(templatePath), dep)
+	if _, err := os.Stat(fromTemplate); err == nil {
+		return fromTemplate
+	}
+	if fromWorkingDir, err := filepath.Abs(dep); err == nil {
+		if _, err := os.Stat(fromWorkingDir); err == nil {
+			return fromWorkingDir
+		}
+	}
+	return fromTemplate
+}
+
+// AnalyzeTemplate reads a template's dependencies and the named templates it uses and defines
+func AnalyzeTemplate(templatePath string) (*TemplateReferences, error) {
+	content, err := os.ReadFile(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading template file: %w", err)
+	}
+
+	deps, err := ResolveTemplateDependencies(templatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Functions are checked at render time, so any function name is accepted here
+	tree := parse.New(filepath.Base(templatePath))
+	tree.Mode = parse.SkipFuncCheck
+	trees := make(map[string]*parse.Tree)
+	if _, err := tree.Parse(string(content), "", "", trees); err != nil {
+		return nil, fmt.Errorf("error parsing template: %w", err)
+	}
+
+	uses := make(map[string]bool)
+	refs := &TemplateReferences{DependsOn: deps}
+	for name, t := range trees {
+		if name != tree.Name {
+			refs.Defines = append(refs.Defines, name)
+		}
+		if t.Root != nil {
+			collectTemplateUses(t.Root, uses)
+		}
+	}
+	for name := range uses {
+		refs.Uses = append(refs.Uses, name)
+	}
+	sort.Strings(refs.Defines)
+	sort.Strings(refs.Uses)
+
+	return refs, nil
+}
+
+// collectTemplateUses records the names of the templates invoked below node
+func collectTemplateUses(node parse.Node, uses map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectTemplateUses(child, uses)
+		}
+	case *parse.TemplateNode:
+		uses[n.Name] = true
+	case *parse.IfNode:
+		collectBranchUses(&n.BranchNode, uses)
+	case *parse.RangeNode:
+		collectBranchUses(&n.BranchNode, uses)
+	case *parse.WithNode:
+		collectBranchUses(&n.BranchNode, uses)
+	}
+}
+
+// collectBranchUses records the template names invoked in both arms of a branch
+func collectBranchUses(branch *parse.BranchNode, uses map[string]bool) {
+	collectTemplateUses(branch.List, uses)
+	if branch.ElseList != nil {
+		collectTemplateUses(branch.ElseList, uses)
+	}
+}
+
+// DependencyClosure returns every depends_on file parsed along with the template,
+// directly or through other dependencies, ordered so that each file comes after its
+// own dependencies
+func DependencyClosure(templatePath string) ([]string, error) {
+	return dependencyClosure(templatePath)
+}
+
+// dependencyClosure returns every file the template depends on, directly or through
+// other dependencies, ordered so that each file comes after its own dependencies
+func dependencyClosure(templatePath string) ([]string, error) {
+	var ordered []string
+	state := make(map[string]int) // 1 while visiting, 2 once done
+	root := filepath.Clean(templatePath)
+
+	var visit func(path string) error
+	visit = func(path string) error {
+		switch state[path] {
+		case 1:
+			return fmt.Errorf("template dependency cycle at %s", path)
+		case 2:
+			return nil
+		}
+		state[path] = 1
+
+		deps, err := ResolveTemplateDependencies(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for _, dep := range deps {
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+
+		state[path] = 2
+		if path != root {
+			ordered = append(ordered, path)
+		}
+		return nil
+	}
+
+	if err := visit(root); err != nil {
+		return nil, err
+	}
+	return ordered, nil
+}
@@ -76,6 +76,11 @@ func RenderDocxTemplate(templatePath string, data map[string]string, opts *DocxO
 		}
 	}
 
+	// Process content controls
+	if err := processContentControls(doc, data, opts); err != nil {
+		return err
+	}
+
 	// Save the rendered document
 	outputPath := strings.TrimSuffix(templatePath, filepath.Ext(templatePath)) + "_rendered.docx"
 	return template.SaveDocxTemplate(outputPath)
@@ -191,10 +196,15 @@ func replaceImagePlaceholder(doc *document.Document, run document.Run, text stri
 		return fmt.Errorf("image data not found for key: %s", key)
 	}
 
-	// Create image struct
-	img := common.Image{
-		Path:   imagePath,
-		Format: filepath.Ext(imagePath)[1:], // Remove the dot from extension
+	return addImageToRun(doc, run, imagePath)
+}
+
+// addImageToRun adds the image at imagePath to the document and draws it inline in the run
+func addImageToRun(doc *document.Document, run document.Run, imagePath string) error {
+	// Read the format and size, which the document needs to add the image
+	img, err := common.ImageFromFile(imagePath)
+	if err != nil {
+		return fmt.Errorf("error reading image: %w", err)
 	}
 
 	// Add image to document
@@ -339,14 +349,30 @@ func copyRunFormatting(src, dst document.Run) {
 
 // replaceTextPlaceholder replaces a text placeholder with its value
 func replaceTextPlaceholder(run document.Run, text string, data map[string]string, opts *DocxOptions) error {
-	// Find all placeholders in the text
-	re := regexp.MustCompile(`{{([^}]+)}}`)
-	matches := re.FindAllStringSubmatch(text, -1)
-
-	if len(matches) == 0 {
+	if !textPlaceholderPattern.MatchString(text) {
 		return nil
 	}
 
+	text, err := fillPlaceholders(text, data)
+	if err != nil {
+		return err
+	}
+
+	// Update run text
+	run.Clear()
+	run.AddText(text)
+
+	return nil
+}
+
+// textPlaceholderPattern matches {{key}} placeholders
+var textPlaceholderPattern = regexp.MustCompile(`{{([^}]+)}}`)
+
+// fillPlaceholders replaces the text placeholders in text with their values
+func fillPlaceholders(text string, data map[string]string) (string, error) {
+	// Find all placeholders in the text
+	matches := textPlaceholderPattern.FindAllStringSubmatch(text, -1)
+
 	// Replace each placeholder
 	for _, match := range matches {
 		if len(match) != 2 {
@@ -364,18 +390,14 @@ func replaceTextPlaceholder(run document.Run, text string, data map[string]strin
 		// Get value from data
 		value, exists := data[key]
 		if !exists {
-			return fmt.Errorf("data not found for key: %s", key)
+			return "", fmt.Errorf("data not found for key: %s", key)
 		}
 
 		// Replace placeholder with value
 		text = strings.Replace(text, placeholder, value, 1)
 	}
 
-	// Update run text
-	run.Clear()
-	run.AddText(text)
-
-	return nil
+	return text, nil
 }
 
 // parseJSONArray parses a JSON array string into a slice of maps
@@ -435,6 +457,17 @@ func ExtractDocxPlaceholders(templatePath string) ([]string, error) {
 		}
 	}
 
+	// Extract from content controls
+	controls, err := collectContentControls(doc)
+	if err != nil {
+		return nil, err
+	}
+	for _, ctrl := range controls {
+		if ctrl.Key() != "" {
+			placeholders[ctrl.Key()] = true
+		}
+	}
+
 	// Convert map to slice
 	var result []string
 	for placeholder := range placeholders {
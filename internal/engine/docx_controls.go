@@ -0,0 +1,744 @@
+package engine
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"baliance.com/gooxml"
+	"baliance.com/gooxml/document"
+	"baliance.com/gooxml/schema/soo/wml"
+)
+
+// ContentControlType identifies the kind of a Word content control
+type ContentControlType string
+
+const (
+	// ContentControlText is a plain text content control
+	ContentControlText ContentControlType = "text"
+	// ContentControlRichText is a rich text content control
+	ContentControlRichText ContentControlType = "richText"
+	// ContentControlPicture is a picture content control
+	ContentControlPicture ContentControlType = "picture"
+	// ContentControlRepeatingSection is a repeating section content control
+	ContentControlRepeatingSection ContentControlType = "repeatingSection"
+	// ContentControlCheckbox is a checkbox content control
+	ContentControlCheckbox ContentControlType = "checkbox"
+	// ContentControlDate is a date picker content control
+	ContentControlDate ContentControlType = "date"
+)
+
+// ContentControl describes a content control (structured document tag) in a DOCX template
+type ContentControl struct {
+	Tag   string
+	Alias string
+	Type  ContentControlType
+}
+
+// Key returns the data key the content control binds to, preferring the tag over the alias
+func (c ContentControl) Key() string {
+	if c.Tag != "" {
+		return c.Tag
+	}
+	return c.Alias
+}
+
+// ExtractDocxContentControls lists the content controls found in a DOCX template
+func ExtractDocxContentControls(templatePath string) ([]ContentControl, error) {
+	doc, err := document.Open(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("error opening DOCX file: %w", err)
+	}
+
+	return collectContentControls(doc)
+}
+
+// collectContentControls returns every content control in the document, leaving it unchanged
+func collectContentControls(doc *document.Document) ([]ContentControl, error) {
+	// A binder without data binds nothing and only records the controls it visits,
+	// so it never modifies the document
+	b := &controlBinder{doc: doc, opts: DefaultDocxOptions()}
+	if err := b.bindBody(); err != nil {
+		return nil, err
+	}
+	return b.seen, nil
+}
+
+// processContentControls binds data to the content controls in the document.
+// Controls without a tag or alias, or whose key is missing from data, are left untouched.
+func processContentControls(doc *document.Document, data map[string]string, opts *DocxOptions) error {
+	b := &controlBinder{doc: doc, data: data, opts: opts}
+	return b.bindBody()
+}
+
+// controlBinder walks the document tree and fills content controls from data
+type controlBinder struct {
+	doc  *document.Document
+	data map[string]string
+	opts *DocxOptions
+	seen []ContentControl
+	// scratch is a document whose body holds the runs built through the document API,
+	// so that building them leaves the body of doc alone
+	scratch *document.Document
+}
+
+// lookup records the control and returns its bound value, if any
+func (b *controlBinder) lookup(pr *wml.CT_SdtPr) (ContentControl, string, bool) {
+	ctrl := describeContentControl(pr)
+	b.seen = append(b.seen, ctrl)
+	if ctrl.Key() == "" {
+		return ctrl, "", false
+	}
+	value, exists := b.data[ctrl.Key()]
+	return ctrl, value, exists
+}
+
+// bindBody binds the controls in the document body
+func (b *controlBinder) bindBody() error {
+	body := b.doc.X().Body
+	if body == nil {
+		return nil
+	}
+
+	for _, ble := range body.EG_BlockLevelElts {
+		for _, c := range ble.EG_ContentBlockContent {
+			if err := b.bindBlocks(c.Sdt, c.P, c.Tbl); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// bindBlocks binds the controls in a run of block-level content
+func (b *controlBinder) bindBlocks(sdt *wml.CT_SdtBlock, paras []*wml.CT_P, tables []*wml.CT_Tbl) error {
+	if sdt != nil {
+		if err := b.bindBlockControl(sdt); err != nil {
+			return err
+		}
+	}
+	for _, p := range paras {
+		if err := b.bindParagraph(p); err != nil {
+			return err
+		}
+	}
+	for _, t := range tables {
+		if err := b.bindTable(t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindBlockControl fills a block-level content control
+func (b *controlBinder) bindBlockControl(sdt *wml.CT_SdtBlock) error {
+	ctrl, value, exists := b.lookup(sdt.SdtPr)
+	content := sdt.SdtContent
+	if !exists {
+		if content == nil {
+			return nil
+		}
+		// Descend so that nested controls are still bound
+		return b.bindBlocks(content.Sdt, content.P, content.Tbl)
+	}
+	if content == nil {
+		content = wml.NewCT_SdtContentBlock()
+		sdt.SdtContent = content
+	}
+
+	var err error
+	switch ctrl.Type {
+	case ContentControlRepeatingSection:
+		err = b.repeatBlocks(ctrl, content, value)
+	case ContentControlPicture:
+		var run *wml.CT_R
+		run, err = b.imageRun(value)
+		if err == nil {
+			content.P = []*wml.CT_P{newParagraph(firstParagraphProps(content.P), run)}
+		}
+	default:
+		var run *wml.CT_R
+		run, err = b.textRun(firstBlockRunProps(content.P), controlText(ctrl, sdt.SdtPr, value))
+		if err == nil {
+			content.P = []*wml.CT_P{newParagraph(firstParagraphProps(content.P), run)}
+			content.Tbl = nil
+			content.Sdt = nil
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("content control %q: %w", ctrl.Key(), err)
+	}
+
+	markControlFilled(sdt.SdtPr)
+	return nil
+}
+
+// bindParagraph binds the inline content controls in a paragraph
+func (b *controlBinder) bindParagraph(p *wml.CT_P) error {
+	for _, pc := range p.EG_PContent {
+		if err := b.bindParagraphContent(pc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindParagraphContent binds the inline content controls in a paragraph content group
+func (b *controlBinder) bindParagraphContent(pc *wml.EG_PContent) error {
+	for _, rc := range pc.EG_ContentRunContent {
+		if rc.Sdt == nil {
+			continue
+		}
+		if err := b.bindRunControl(rc.Sdt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindRunControl fills an inline content control
+func (b *controlBinder) bindRunControl(sdt *wml.CT_SdtRun) error {
+	ctrl, value, exists := b.lookup(sdt.SdtPr)
+	content := sdt.SdtContent
+	if !exists {
+		if content == nil {
+			return nil
+		}
+		for _, pc := range content.EG_PContent {
+			if err := b.bindParagraphContent(pc); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if content == nil {
+		content = wml.NewCT_SdtContentRun()
+		sdt.SdtContent = content
+	}
+
+	var run *wml.CT_R
+	var err error
+	switch ctrl.Type {
+	case ContentControlRepeatingSection:
+		err = fmt.Errorf("repeating sections must wrap whole paragraphs or table rows")
+	case ContentControlPicture:
+		run, err = b.imageRun(value)
+	default:
+		run, err = b.textRun(firstRunProps(content.EG_PContent), controlText(ctrl, sdt.SdtPr, value))
+	}
+	if err != nil {
+		return fmt.Errorf("content control %q: %w", ctrl.Key(), err)
+	}
+
+	content.EG_PContent = []*wml.EG_PContent{newRunContent(run)}
+	markControlFilled(sdt.SdtPr)
+	return nil
+}
+
+// bindTable binds the content controls in a table
+func (b *controlBinder) bindTable(t *wml.CT_Tbl) error {
+	for _, rc := range t.EG_ContentRowContent {
+		if rc.Sdt != nil {
+			if err := b.bindRowControl(rc.Sdt); err != nil {
+				return err
+			}
+		}
+		for _, row := range rc.Tr {
+			if err := b.bindRow(row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// bindRow binds the content controls in a table row
+func (b *controlBinder) bindRow(row *wml.CT_Row) error {
+	for _, cc := range row.EG_ContentCellContent {
+		cells := append([]*wml.CT_Tc{}, cc.Tc...)
+		if cc.Sdt != nil && cc.Sdt.SdtContent != nil {
+			cells = append(cells, cc.Sdt.SdtContent.Tc...)
+		}
+		for _, cell := range cells {
+			for _, ble := range cell.EG_BlockLevelElts {
+				for _, c := range ble.EG_ContentBlockContent {
+					if err := b.bindBlocks(c.Sdt, c.P, c.Tbl); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// bindRowControl fills a content control wrapping table rows
+func (b *controlBinder) bindRowControl(sdt *wml.CT_SdtRow) error {
+	ctrl, value, exists := b.lookup(sdt.SdtPr)
+	content := sdt.SdtContent
+	if content == nil {
+		// Nothing to bind or repeat
+		return nil
+	}
+	if !exists || ctrl.Type != ContentControlRepeatingSection {
+		rows := append([]*wml.CT_Row{}, content.Tr...)
+		if content.Sdt != nil && content.Sdt.SdtContent != nil {
+			rows = append(rows, content.Sdt.SdtContent.Tr...)
+		}
+		for _, row := range rows {
+			if err := b.bindRow(row); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// Word wraps each repetition in a repeating section item control
+	templateRows := append([]*wml.CT_Row{}, content.Tr...)
+	if content.Sdt != nil && content.Sdt.SdtContent != nil {
+		templateRows = append(templateRows, content.Sdt.SdtContent.Tr...)
+	}
+
+	var rows []*wml.CT_Row
+	for _, item := range parseJSONArray(value) {
+		for _, tpl := range templateRows {
+			row, err := b.cloneRow(tpl, item)
+			if err != nil {
+				return fmt.Errorf("content control %q: %w", ctrl.Key(), err)
+			}
+			rows = append(rows, row)
+		}
+	}
+
+	content.Tr = rows
+	content.Sdt = nil
+	markControlFilled(sdt.SdtPr)
+	return nil
+}
+
+// repeatBlocks repeats the paragraphs of a block-level repeating section once per array item
+func (b *controlBinder) repeatBlocks(ctrl ContentControl, content *wml.CT_SdtContentBlock, value string) error {
+	// Word wraps each repetition in a repeating section item control
+	templateParas := append([]*wml.CT_P{}, content.P...)
+	if content.Sdt != nil && content.Sdt.SdtContent != nil {
+		templateParas = append(templateParas, content.Sdt.SdtContent.P...)
+	}
+
+	var paras []*wml.CT_P
+	for _, item := range parseJSONArray(value) {
+		for _, tpl := range templateParas {
+			p, err := b.cloneParagraph(tpl, item)
+			if err != nil {
+				return err
+			}
+			paras = append(paras, p)
+		}
+	}
+
+	content.P = paras
+	content.Sdt = nil
+	return nil
+}
+
+// cloneRow copies a template row, filling its placeholders and controls from item
+func (b *controlBinder) cloneRow(tpl *wml.CT_Row, item map[string]string) (*wml.CT_Row, error) {
+	row := wml.NewCT_Row()
+	row.TrPr = tpl.TrPr
+
+	for _, cc := range tpl.EG_ContentCellContent {
+		newCC := wml.NewEG_ContentCellContent()
+		for _, tc := range cc.Tc {
+			cell := wml.NewCT_Tc()
+			cell.TcPr = tc.TcPr
+
+			ble := wml.NewEG_BlockLevelElts()
+			block := wml.NewEG_ContentBlockContent()
+			ble.EG_ContentBlockContent = append(ble.EG_ContentBlockContent, block)
+			cell.EG_BlockLevelElts = append(cell.EG_BlockLevelElts, ble)
+
+			for _, tcBle := range tc.EG_BlockLevelElts {
+				for _, c := range tcBle.EG_ContentBlockContent {
+					for _, p := range c.P {
+						newP, err := b.cloneParagraph(p, item)
+						if err != nil {
+							return nil, err
+						}
+						block.P = append(block.P, newP)
+					}
+				}
+			}
+
+			// A table cell must contain at least one paragraph
+			if len(block.P) == 0 {
+				block.P = append(block.P, wml.NewCT_P())
+			}
+			newCC.Tc = append(newCC.Tc, cell)
+		}
+		row.EG_ContentCellContent = append(row.EG_ContentCellContent, newCC)
+	}
+
+	return row, nil
+}
+
+// cloneParagraph copies a template paragraph, filling its placeholders and controls from item
+func (b *controlBinder) cloneParagraph(tpl *wml.CT_P, item map[string]string) (*wml.CT_P, error) {
+	p := wml.NewCT_P()
+	p.PPr = tpl.PPr
+
+	for _, pc := range tpl.EG_PContent {
+		for _, rc := range pc.EG_ContentRunContent {
+			var props *wml.CT_RPr
+			var text string
+			switch {
+			case rc.R != nil:
+				filled, err := fillPlaceholders(runText(rc.R), item)
+				if err != nil {
+					return nil, err
+				}
+				props, text = rc.R.RPr, filled
+			case rc.Sdt != nil && rc.Sdt.SdtContent != nil:
+				ctrl := describeContentControl(rc.Sdt.SdtPr)
+				props = firstRunProps(rc.Sdt.SdtContent.EG_PContent)
+				if value, exists := item[ctrl.Key()]; exists && ctrl.Key() != "" {
+					// Every item fills the same template, so it must not be changed
+					text = controlText(ctrl, copySdtPr(rc.Sdt.SdtPr), value)
+				} else {
+					text = contentText(rc.Sdt.SdtContent.EG_PContent)
+				}
+			default:
+				continue
+			}
+
+			run, err := b.textRun(props, text)
+			if err != nil {
+				return nil, err
+			}
+			p.EG_PContent = append(p.EG_PContent, newRunContent(run))
+		}
+	}
+
+	return p, nil
+}
+
+// textRun builds a run holding text, turning newlines into line breaks
+func (b *controlBinder) textRun(props *wml.CT_RPr, text string) (*wml.CT_R, error) {
+	run, err := b.detachedRun(func(r document.Run) error {
+		for i, line := range strings.Split(text, "\n") {
+			if i > 0 {
+				r.AddBreak()
+			}
+			r.AddText(line)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if b.opts.PreserveFormatting {
+		run.RPr = props
+	}
+	return run, nil
+}
+
+// imageRun builds a run drawing the image at imagePath, which is added to the document
+func (b *controlBinder) imageRun(imagePath string) (*wml.CT_R, error) {
+	run, err := b.detachedRun(func(r document.Run) error {
+		return addImageToRun(b.doc, r, imagePath)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The drawing was numbered after the images of the scratch document, which has none
+	for _, ic := range run.EG_RunInnerContent {
+		if ic.Drawing == nil {
+			continue
+		}
+		for _, inline := range ic.Drawing.Inline {
+			if inline.DocPr != nil {
+				inline.DocPr.IdAttr = uint32(len(b.doc.Images))
+			}
+		}
+	}
+	return run, nil
+}
+
+// detachedRun builds a run through the document API in a paragraph of the scratch
+// document, so it can be grafted into a content control of the document
+func (b *controlBinder) detachedRun(build func(document.Run) error) (*wml.CT_R, error) {
+	if b.scratch == nil {
+		b.scratch = document.New()
+	}
+	// Only the run is kept, so the scratch body is emptied again
+	defer func() { b.scratch.X().Body.EG_BlockLevelElts = nil }()
+
+	run := b.scratch.AddParagraph().AddRun()
+	if err := build(run); err != nil {
+		return nil, err
+	}
+	return run.X(), nil
+}
+
+// describeContentControl reads the tag, alias and type from content control properties
+func describeContentControl(pr *wml.CT_SdtPr) ContentControl {
+	ctrl := ContentControl{Type: ContentControlRichText}
+	if pr == nil {
+		return ctrl
+	}
+
+	if pr.Tag != nil {
+		ctrl.Tag = strings.TrimSpace(pr.Tag.ValAttr)
+	}
+	if pr.Alias != nil {
+		ctrl.Alias = strings.TrimSpace(pr.Alias.ValAttr)
+	}
+
+	if choice := pr.Choice; choice != nil {
+		switch {
+		case choice.Text != nil:
+			ctrl.Type = ContentControlText
+		case choice.Picture != nil:
+			ctrl.Type = ContentControlPicture
+		case choice.Date != nil:
+			ctrl.Type = ContentControlDate
+		}
+	}
+
+	// Checkboxes and repeating sections are Word 2010+ extensions
+	for _, ext := range pr.Extra {
+		if x, ok := ext.(*gooxml.XSDAny); ok {
+			switch x.XMLName.Local {
+			case "checkbox":
+				ctrl.Type = ContentControlCheckbox
+			case "repeatingSection":
+				ctrl.Type = ContentControlRepeatingSection
+			}
+		}
+	}
+
+	return ctrl
+}
+
+// controlText converts a data value into the text displayed by a content control
+func controlText(ctrl ContentControl, pr *wml.CT_SdtPr, value string) string {
+	switch ctrl.Type {
+	case ContentControlCheckbox:
+		return string(setCheckboxState(pr, isTruthy(value)))
+	case ContentControlDate:
+		return formatControlDate(pr, value)
+	default:
+		return value
+	}
+}
+
+// copySdtPr returns a copy of content control properties whose extensions, such as the
+// checkbox state, can be changed without affecting pr
+func copySdtPr(pr *wml.CT_SdtPr) *wml.CT_SdtPr {
+	if pr == nil {
+		return nil
+	}
+	c := *pr
+	c.Extra = make([]gooxml.Any, len(pr.Extra))
+	for i, ext := range pr.Extra {
+		if x, ok := ext.(*gooxml.XSDAny); ok {
+			ext = copyXSDAny(x)
+		}
+		c.Extra[i] = ext
+	}
+	return &c
+}
+
+// copyXSDAny returns a deep copy of an unknown XML element
+func copyXSDAny(x *gooxml.XSDAny) *gooxml.XSDAny {
+	c := &gooxml.XSDAny{
+		XMLName: x.XMLName,
+		Attrs:   append([]xml.Attr(nil), x.Attrs...),
+		Data:    append([]byte(nil), x.Data...),
+	}
+	for _, node := range x.Nodes {
+		c.Nodes = append(c.Nodes, copyXSDAny(node))
+	}
+	return c
+}
+
+// isTruthy reports whether a data value counts as true, matching {{#if}} semantics
+func isTruthy(value string) bool {
+	return value != "" && value != "false" && value != "0"
+}
+
+// setCheckboxState records the checked state on a checkbox control and returns the glyph to display
+func setCheckboxState(pr *wml.CT_SdtPr, checked bool) rune {
+	glyph := '☐'
+	if checked {
+		glyph = '☒'
+	}
+
+	for _, ext := range pr.Extra {
+		x, ok := ext.(*gooxml.XSDAny)
+		if !ok || x.XMLName.Local != "checkbox" {
+			continue
+		}
+		for _, node := range x.Nodes {
+			switch node.XMLName.Local {
+			case "checked":
+				for i, attr := range node.Attrs {
+					if attr.Name.Local == "val" {
+						node.Attrs[i].Value = "0"
+						if checked {
+							node.Attrs[i].Value = "1"
+						}
+					}
+				}
+			case "checkedState", "uncheckedState":
+				if (node.XMLName.Local == "checkedState") != checked {
+					continue
+				}
+				// The state glyph is stored as a hexadecimal character code
+				for _, attr := range node.Attrs {
+					if attr.Name.Local != "val" {
+						continue
+					}
+					if code, err := strconv.ParseInt(attr.Value, 16, 32); err == nil {
+						glyph = rune(code)
+					}
+				}
+			}
+		}
+	}
+
+	return glyph
+}
+
+// formatControlDate formats a date value using the date control's display format
+func formatControlDate(pr *wml.CT_SdtPr, value string) string {
+	var date time.Time
+	var err error
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if date, err = time.Parse(layout, value); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		// Not a date we understand, show it as given
+		return value
+	}
+
+	format := "M/d/yyyy"
+	if pr.Choice.Date.DateFormat != nil && pr.Choice.Date.DateFormat.ValAttr != "" {
+		format = pr.Choice.Date.DateFormat.ValAttr
+	}
+	return date.Format(wordDateLayout(format))
+}
+
+// wordDateTokens maps Word date format tokens to Go layout elements, longest first
+var wordDateTokens = []struct {
+	word   string
+	layout string
+}{
+	{"yyyy", "2006"}, {"yy", "06"},
+	{"MMMM", "January"}, {"MMM", "Jan"}, {"MM", "01"}, {"M", "1"},
+	{"dddd", "Monday"}, {"ddd", "Mon"}, {"dd", "02"}, {"d", "2"},
+	{"HH", "15"}, {"hh", "03"}, {"h", "3"},
+	{"mm", "04"}, {"ss", "05"}, {"AM/PM", "PM"},
+}
+
+// wordDateLayout converts a Word date format such as "dd MMMM yyyy" into a Go time layout
+func wordDateLayout(format string) string {
+	var layout strings.Builder
+	for i := 0; i < len(format); {
+		matched := false
+		for _, tok := range wordDateTokens {
+			if strings.HasPrefix(format[i:], tok.word) {
+				layout.WriteString(tok.layout)
+				i += len(tok.word)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			layout.WriteByte(format[i])
+			i++
+		}
+	}
+	return layout.String()
+}
+
+// markControlFilled clears the flag that makes Word render the control as placeholder text
+func markControlFilled(pr *wml.CT_SdtPr) {
+	if pr != nil {
+		pr.ShowingPlcHdr = nil
+	}
+}
+
+// newParagraph builds a paragraph containing a single run
+func newParagraph(props *wml.CT_PPr, run *wml.CT_R) *wml.CT_P {
+	p := wml.NewCT_P()
+	p.PPr = props
+	p.EG_PContent = append(p.EG_PContent, newRunContent(run))
+	return p
+}
+
+// newRunContent wraps a run in paragraph content
+func newRunContent(run *wml.CT_R) *wml.EG_PContent {
+	rc := wml.NewEG_ContentRunContent()
+	rc.R = run
+	pc := wml.NewEG_PContent()
+	pc.EG_ContentRunContent = append(pc.EG_ContentRunContent, rc)
+	return pc
+}
+
+// firstParagraphProps returns the properties of the first paragraph, if any
+func firstParagraphProps(paras []*wml.CT_P) *wml.CT_PPr {
+	if len(paras) == 0 {
+		return nil
+	}
+	return paras[0].PPr
+}
+
+// firstBlockRunProps returns the properties of the first run in the paragraphs, if any
+func firstBlockRunProps(paras []*wml.CT_P) *wml.CT_RPr {
+	for _, p := range paras {
+		if props := firstRunProps(p.EG_PContent); props != nil {
+			return props
+		}
+	}
+	return nil
+}
+
+// firstRunProps returns the properties of the first run in the content, if any
+func firstRunProps(content []*wml.EG_PContent) *wml.CT_RPr {
+	for _, pc := range content {
+		for _, rc := range pc.EG_ContentRunContent {
+			if rc.R != nil {
+				return rc.R.RPr
+			}
+		}
+	}
+	return nil
+}
+
+// contentText concatenates the text of the runs in the content
+func contentText(content []*wml.EG_PContent) string {
+	var text strings.Builder
+	for _, pc := range content {
+		for _, rc := range pc.EG_ContentRunContent {
+			if rc.R != nil {
+				text.WriteString(runText(rc.R))
+			}
+		}
+	}
+	return text.String()
+}
+
+// runText returns the text of a run
+func runText(r *wml.CT_R) string {
+	var text strings.Builder
+	for _, ic := range r.EG_RunInnerContent {
+		if ic.T != nil {
+			text.WriteString(ic.T.Content)
+		}
+	}
+	return text.String()
+}
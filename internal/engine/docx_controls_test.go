@@ -0,0 +1,304 @@
+package engine
+
+import (
+	"encoding/xml"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"baliance.com/gooxml"
+	"baliance.com/gooxml/document"
+	"baliance.com/gooxml/schema/soo/wml"
+)
+
+// w14 is the namespace of the Word 2010 content control extensions
+const w14 = "http://schemas.microsoft.com/office/word/2010/wordml"
+
+// controlProps returns the properties of a content control with the given tag
+func controlProps(tag string) *wml.CT_SdtPr {
+	pr := wml.NewCT_SdtPr()
+	pr.Tag = wml.NewCT_String()
+	pr.Tag.ValAttr = tag
+	return pr
+}
+
+// w14Element returns a Word 2010 extension element with a val attribute, if given
+func w14Element(name, val string, children ...*gooxml.XSDAny) *gooxml.XSDAny {
+	x := &gooxml.XSDAny{XMLName: xml.Name{Space: w14, Local: name}, Nodes: children}
+	if val != "" {
+		x.Attrs = []xml.Attr{{Name: xml.Name{Space: w14, Local: "val"}, Value: val}}
+	}
+	return x
+}
+
+// addBlockControl appends a block-level content control holding paras to the body
+func addBlockControl(doc *document.Document, pr *wml.CT_SdtPr, paras ...*wml.CT_P) *wml.CT_SdtBlock {
+	sdt := wml.NewCT_SdtBlock()
+	sdt.SdtPr = pr
+	sdt.SdtContent = wml.NewCT_SdtContentBlock()
+	sdt.SdtContent.P = paras
+
+	c := wml.NewEG_ContentBlockContent()
+	c.Sdt = sdt
+	ble := wml.NewEG_BlockLevelElts()
+	ble.EG_ContentBlockContent = append(ble.EG_ContentBlockContent, c)
+	doc.X().Body.EG_BlockLevelElts = append(doc.X().Body.EG_BlockLevelElts, ble)
+	return sdt
+}
+
+// textParagraph returns a paragraph with a single run of text
+func textParagraph(text string) *wml.CT_P {
+	ic := wml.NewEG_RunInnerContent()
+	ic.T = wml.NewCT_Text()
+	ic.T.Content = text
+	run := wml.NewCT_R()
+	run.EG_RunInnerContent = append(run.EG_RunInnerContent, ic)
+	return newParagraph(nil, run)
+}
+
+// paragraphTexts returns the text of each paragraph of a block-level control
+func paragraphTexts(sdt *wml.CT_SdtBlock) []string {
+	var texts []string
+	for _, p := range sdt.SdtContent.P {
+		texts = append(texts, contentText(p.EG_PContent))
+	}
+	return texts
+}
+
+// firstRun returns the first run of a block-level control
+func firstRun(t *testing.T, sdt *wml.CT_SdtBlock) *wml.CT_R {
+	t.Helper()
+	for _, p := range sdt.SdtContent.P {
+		for _, pc := range p.EG_PContent {
+			for _, rc := range pc.EG_ContentRunContent {
+				if rc.R != nil {
+					return rc.R
+				}
+			}
+		}
+	}
+	t.Fatal("content control holds no run")
+	return nil
+}
+
+// writePNG writes a small PNG image and returns its path
+func writePNG(t *testing.T) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "logo.png")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, image.NewRGBA(image.Rect(0, 0, 2, 2))); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProcessContentControls(t *testing.T) {
+	doc := document.New()
+
+	textPr := controlProps("name")
+	textPr.Choice = wml.NewCT_SdtPrChoice()
+	textPr.Choice.Text = wml.NewCT_SdtText()
+	textPr.ShowingPlcHdr = wml.NewCT_OnOff()
+	text := addBlockControl(doc, textPr, textParagraph("Click here to enter text."))
+
+	richText := addBlockControl(doc, controlProps("notes"), textParagraph("Notes"))
+
+	checkboxPr := controlProps("agree")
+	checkboxPr.Extra = append(checkboxPr.Extra, w14Element("checkbox", "",
+		w14Element("checked", "0"),
+		w14Element("checkedState", "2612"),
+		w14Element("uncheckedState", "2610"),
+	))
+	checkbox := addBlockControl(doc, checkboxPr, textParagraph("☐"))
+
+	datePr := controlProps("due")
+	datePr.Choice = wml.NewCT_SdtPrChoice()
+	datePr.Choice.Date = wml.NewCT_SdtDate()
+	datePr.Choice.Date.DateFormat = wml.NewCT_String()
+	datePr.Choice.Date.DateFormat.ValAttr = "dd MMMM yyyy"
+	date := addBlockControl(doc, datePr, textParagraph("Pick a date"))
+
+	picturePr := controlProps("logo")
+	picturePr.Choice = wml.NewCT_SdtPrChoice()
+	picturePr.Choice.Picture = wml.NewCT_Empty()
+	picture := addBlockControl(doc, picturePr, wml.NewCT_P())
+
+	sectionPr := controlProps("items")
+	sectionPr.Extra = append(sectionPr.Extra, w14Element("repeatingSection", ""))
+	section := addBlockControl(doc, sectionPr, textParagraph("- {{name}}"))
+
+	controls, err := collectContentControls(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantTypes := []ContentControlType{
+		ContentControlText, ContentControlRichText, ContentControlCheckbox,
+		ContentControlDate, ContentControlPicture, ContentControlRepeatingSection,
+	}
+	if len(controls) != len(wantTypes) {
+		t.Fatalf("found %d content controls, want %d", len(controls), len(wantTypes))
+	}
+	for i, ctrl := range controls {
+		if ctrl.Type != wantTypes[i] {
+			t.Errorf("control %s has type %s, want %s", ctrl.Key(), ctrl.Type, wantTypes[i])
+		}
+	}
+
+	bodyElements := len(doc.X().Body.EG_BlockLevelElts)
+	data := map[string]string{
+		"name":  "Ada",
+		"notes": "first\nsecond",
+		"agree": "true",
+		"due":   "2024-03-05",
+		"logo":  writePNG(t),
+		"items": `[{"name": "one"}, {"name": "two"}]`,
+	}
+	if err := processContentControls(doc, data, DefaultDocxOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Runs are built without adding paragraphs to the body
+	if got := len(doc.X().Body.EG_BlockLevelElts); got != bodyElements {
+		t.Errorf("body has %d elements after binding, want %d", got, bodyElements)
+	}
+
+	tests := []struct {
+		name string
+		sdt  *wml.CT_SdtBlock
+		want []string
+	}{
+		{"text", text, []string{"Ada"}},
+		{"rich text", richText, []string{"firstsecond"}},
+		{"checkbox", checkbox, []string{"☒"}},
+		{"date", date, []string{"05 March 2024"}},
+		{"repeating section", section, []string{"- one", "- two"}},
+	}
+	for _, tt := range tests {
+		got := paragraphTexts(tt.sdt)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s control holds %q, want %q", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s control holds %q, want %q", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+
+	if text.SdtPr.ShowingPlcHdr != nil {
+		t.Error("filled text control is still marked as showing its placeholder")
+	}
+
+	breaks := 0
+	for _, ic := range firstRun(t, richText).EG_RunInnerContent {
+		if ic.Br != nil {
+			breaks++
+		}
+	}
+	if breaks != 1 {
+		t.Errorf("rich text run has %d line breaks, want 1", breaks)
+	}
+
+	checked := checkboxPr.Extra[0].(*gooxml.XSDAny).Nodes[0]
+	if checked.Attrs[0].Value != "1" {
+		t.Errorf("checkbox checked state is %q, want 1", checked.Attrs[0].Value)
+	}
+
+	drawings := 0
+	for _, ic := range firstRun(t, picture).EG_RunInnerContent {
+		if ic.Drawing != nil {
+			drawings += len(ic.Drawing.Inline)
+		}
+	}
+	if drawings != 1 || len(doc.Images) != 1 {
+		t.Errorf("picture control has %d drawings and the document %d images, want 1 of each", drawings, len(doc.Images))
+	}
+
+	// The bound document saves and still lists its controls
+	out := filepath.Join(t.TempDir(), "out.docx")
+	if err := doc.SaveToFile(out); err != nil {
+		t.Fatal(err)
+	}
+	saved, err := ExtractDocxContentControls(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved) != len(wantTypes) {
+		t.Errorf("saved document has %d content controls, want %d", len(saved), len(wantTypes))
+	}
+}
+
+func TestProcessContentControlsLeavesUnboundControls(t *testing.T) {
+	doc := document.New()
+	sdt := addBlockControl(doc, controlProps("missing"), textParagraph("placeholder"))
+
+	if err := processContentControls(doc, map[string]string{}, DefaultDocxOptions()); err != nil {
+		t.Fatal(err)
+	}
+	if got := paragraphTexts(sdt); len(got) != 1 || got[0] != "placeholder" {
+		t.Errorf("control without data holds %q, want it untouched", got)
+	}
+}
+
+func TestCollectContentControlsLeavesDocument(t *testing.T) {
+	doc := document.New()
+	sdt := addBlockControl(doc, controlProps("empty"))
+	sdt.SdtContent = nil
+
+	controls, err := collectContentControls(doc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(controls) != 1 || controls[0].Key() != "empty" {
+		t.Errorf("found %+v, want the empty control", controls)
+	}
+	if sdt.SdtContent != nil {
+		t.Error("collecting content controls added content to an empty control")
+	}
+}
+
+func TestRepeatingSectionLeavesCheckboxTemplate(t *testing.T) {
+	doc := document.New()
+
+	checkboxPr := controlProps("done")
+	checkboxPr.Extra = append(checkboxPr.Extra, w14Element("checkbox", "",
+		w14Element("checked", "0"),
+		w14Element("checkedState", "2612"),
+		w14Element("uncheckedState", "2610"),
+	))
+	checkbox := wml.NewCT_SdtRun()
+	checkbox.SdtPr = checkboxPr
+	checkbox.SdtContent = wml.NewCT_SdtContentRun()
+	checkbox.SdtContent.EG_PContent = textParagraph("☐").EG_PContent
+	rc := wml.NewEG_ContentRunContent()
+	rc.Sdt = checkbox
+	pc := wml.NewEG_PContent()
+	pc.EG_ContentRunContent = append(pc.EG_ContentRunContent, rc)
+	item := wml.NewCT_P()
+	item.EG_PContent = append(item.EG_PContent, pc)
+
+	sectionPr := controlProps("tasks")
+	sectionPr.Extra = append(sectionPr.Extra, w14Element("repeatingSection", ""))
+	section := addBlockControl(doc, sectionPr, item)
+
+	data := map[string]string{"tasks": `[{"done": "true"}, {"done": "false"}]`}
+	if err := processContentControls(doc, data, DefaultDocxOptions()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := paragraphTexts(section); len(got) != 2 || got[0] != "☒" || got[1] != "☐" {
+		t.Errorf("repeating section holds %q, want a checked and an unchecked box", got)
+	}
+	checked := checkboxPr.Extra[0].(*gooxml.XSDAny).Nodes[0]
+	if checked.Attrs[0].Value != "0" {
+		t.Errorf("template checkbox checked state is %q, want it left at 0", checked.Attrs[0].Value)
+	}
+}
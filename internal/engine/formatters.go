@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/format"
+	"go/scanner"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// extensionFormatters names the built-in post-processors applied to outputs by extension
+var extensionFormatters = map[string][]string{
+	".go":   {"gofmt"},
+	".json": {"json"},
+	".yaml": {"yaml"},
+	".yml":  {"yaml"},
+}
+
+// SyntaxError reports generated content that a formatter cannot parse, at the line
+// and column of the output
+type SyntaxError struct {
+	Path    string
+	Line    int
+	Column  int
+	Message string
+	// Source is the offending line of the output
+	Source string
+}
+
+func (e *SyntaxError) Error() string {
+	msg := fmt.Sprintf("%s:%d:%d: %s", e.Path, e.Line, e.Column, e.Message)
+	if e.Source != "" {
+		msg += "\n\t" + e.Source
+	}
+	return msg
+}
+
+// newSyntaxError returns a SyntaxError at line and column of content
+func newSyntaxError(path, content string, line, column int, message string) *SyntaxError {
+	err := &SyntaxError{Path: path, Line: line, Column: column, Message: message}
+	if lines := strings.Split(content, "\n"); line >= 1 && line <= len(lines) {
+		err.Source = strings.TrimRight(lines[line-1], "\r")
+	}
+	return err
+}
+
+// Formatters returns the names of the built-in post-processors that format outputs
+// written to path, chosen by its extension
+func Formatters(path string) []string {
+	return extensionFormatters[strings.ToLower(filepath.Ext(path))]
+}
+
+// FormatOutput formats content written to path with the post-processors returned by Formatters
+func FormatOutput(path, content string) (string, error) {
+	return ApplyPostProcessors(Formatters(path), path, content)
+}
+
+// formatGo formats Go source with go/format, reporting syntax errors at their line of the output
+func formatGo(path, content string) (string, error) {
+	if strings.TrimSpace(content) == "" {
+		return content, nil
+	}
+
+	formatted, err := format.Source([]byte(content))
+	if err != nil {
+		var list scanner.ErrorList
+		if !errors.As(err, &list) {
+			return "", err
+		}
+		errs := make([]error, len(list))
+		for i, e := range list {
+			errs[i] = newSyntaxError(path, content, e.Pos.Line, e.Pos.Column, e.Msg)
+		}
+		return "", errors.Join(errs...)
+	}
+	return string(formatted), nil
+}
+
+// formatJSON validates JSON and indents it with two spaces, keeping the order of keys
+func formatJSON(path, content string) (string, error) {
+	if strings.TrimSpace(content) == "" {
+		return content, nil
+	}
+
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(content)); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line, column := position(content, int(syntaxErr.Offset))
+			return "", newSyntaxError(path, content, line, column, syntaxErr.Error())
+		}
+		return "", err
+	}
+
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, compact.Bytes(), "", "  "); err != nil {
+		return "", err
+	}
+	indented.WriteByte('\n')
+	return indented.String(), nil
+}
+
+// position returns the line and column of a byte offset in content
+func position(content string, offset int) (int, int) {
+	offset = min(max(offset, 0), len(content))
+	before := content[:offset]
+	line := strings.Count(before, "\n") + 1
+	return line, offset - strings.LastIndex(before, "\n")
+}
+
+// validateYAML checks that every document of content parses and encodes back to the
+// same values, leaving content as it is so comments and layout are kept
+func validateYAML(path, content string) (string, error) {
+	documents, err := decodeYAML(content)
+	if err != nil {
+		return "", err
+	}
+
+	var encoded bytes.Buffer
+	encoder := yaml.NewEncoder(&encoded)
+	for _, document := range documents {
+		if err := encoder.Encode(document); err != nil {
+			return "", fmt.Errorf("error encoding YAML: %w", err)
+		}
+	}
+	if err := encoder.Close(); err != nil {
+		return "", fmt.Errorf("%s: error encoding YAML: %w", path, err)
+	}
+
+	decoded, err := decodeYAML(encoded.String())
+	if err != nil || !reflect.DeepEqual(decoded, documents) {
+		return "", fmt.Errorf("YAML does not survive a round trip")
+	}
+	return content, nil
+}
+
+// decodeYAML decodes every document of content
+func decodeYAML(content string) ([]any, error) {
+	var documents []any
+	decoder := yaml.NewDecoder(strings.NewReader(content))
+	for {
+		var document any
+		err := decoder.Decode(&document)
+		if errors.Is(err, io.EOF) {
+			return documents, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		documents = append(documents, document)
+	}
+}
+
+// normalizeWhitespace removes trailing spaces and tabs from every line and ends
+// non-empty content with exactly one newline
+func normalizeWhitespace(path, content string) (string, error) {
+	lines := strings.Split(content, "\n")
+	for i, line := range lines {
+		cr := strings.HasSuffix(line, "\r")
+		line = strings.TrimRight(strings.TrimSuffix(line, "\r"), " \t")
+		if cr {
+			line += "\r"
+		}
+		lines[i] = line
+	}
+
+	content = strings.TrimRight(strings.Join(lines, "\n"), "\r\n")
+	if content == "" {
+		return "", nil
+	}
+	return content + "\n", nil
+}
@@ -0,0 +1,177 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"unicode"
+)
+
+// templateFuncSets stores named template function sets that targets can enable
+var templateFuncSets = struct {
+	sync.RWMutex
+	sets map[string]template.FuncMap
+}{
+	sets: map[string]template.FuncMap{
+		"strings": {
+			"trim":      strings.TrimSpace,
+			"replace":   strings.ReplaceAll,
+			"split":     strings.Split,
+			"contains":  strings.Contains,
+			"hasPrefix": strings.HasPrefix,
+			"hasSuffix": strings.HasSuffix,
+		},
+		"case": {
+			"camel":  camelCase,
+			"pascal": pascalCase,
+			"snake":  func(s string) string { return joinWords(s, "_") },
+			"kebab":  func(s string) string { return joinWords(s, "-") },
+		},
+	},
+}
+
+// RegisterFuncs registers a named set of template functions, replacing any set with the
+// same name. Cached templates parsed with a replaced set are parsed again on next use.
+func RegisterFuncs(name string, funcs template.FuncMap) {
+	templateFuncSets.Lock()
+	templateFuncSets.sets[name] = funcs
+	templateFuncSets.Unlock()
+
+	evictTemplatesUsing(name)
+}
+
+// FuncSetNames returns the names of the registered template function sets
+func FuncSetNames() []string {
+	templateFuncSets.RLock()
+	defer templateFuncSets.RUnlock()
+
+	names := make([]string, 0, len(templateFuncSets.sets))
+	for name := range templateFuncSets.sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FuncSetDefining returns the name of a registered function set that defines the
+// template function fn, or false if none does
+func FuncSetDefining(fn string) (string, bool) {
+	templateFuncSets.RLock()
+	defer templateFuncSets.RUnlock()
+
+	names := make([]string, 0, len(templateFuncSets.sets))
+	for name := range templateFuncSets.sets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, exists := templateFuncSets.sets[name][fn]; exists {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// funcsRevision changes whenever the behaviour of the built-in template functions does
+const funcsRevision = 1
+
+// FuncsVersion identifies the template functions available with the named function
+// sets, so outputs rendered with other functions can be told apart. It covers the
+// names of the functions, not what registered functions do.
+func FuncsVersion(sets []string) (string, error) {
+	funcs, err := resolveFuncs(sets)
+	if err != nil {
+		return "", err
+	}
+	names := make([]string, 0, len(funcs))
+	for name := range funcs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d\n%s\n%s", funcsRevision, strings.Join(sets, ","), strings.Join(names, ","))
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// resolveFuncs merges the default template functions with the named function sets
+func resolveFuncs(names []string) (template.FuncMap, error) {
+	funcs := make(template.FuncMap, len(TemplateFuncs))
+	for name, fn := range TemplateFuncs {
+		funcs[name] = fn
+	}
+
+	templateFuncSets.RLock()
+	defer templateFuncSets.RUnlock()
+	for _, name := range names {
+		set, exists := templateFuncSets.sets[name]
+		if !exists {
+			return nil, fmt.Errorf("unknown template function set: %s", name)
+		}
+		for fnName, fn := range set {
+			funcs[fnName] = fn
+		}
+	}
+
+	return funcs, nil
+}
+
+// splitWords splits an identifier such as "userID", "user_id" or "User Name" into lower case words
+func splitWords(s string) []string {
+	var words []string
+	var current []rune
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case !unicode.IsLetter(r) && !unicode.IsDigit(r):
+			if len(current) > 0 {
+				words = append(words, string(current))
+				current = nil
+			}
+			continue
+		case unicode.IsUpper(r) && len(current) > 0:
+			// Start a new word at "aB" and at the last capital of "ABc"
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				words = append(words, string(current))
+				current = nil
+			}
+		}
+		current = append(current, unicode.ToLower(r))
+	}
+	if len(current) > 0 {
+		words = append(words, string(current))
+	}
+	return words
+}
+
+// joinWords joins the words of s in lower case with sep
+func joinWords(s, sep string) string {
+	return strings.Join(splitWords(s), sep)
+}
+
+// pascalCase converts s to PascalCase
+func pascalCase(s string) string {
+	var b strings.Builder
+	for _, word := range splitWords(s) {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		b.WriteString(string(runes))
+	}
+	return b.String()
+}
+
+// camelCase converts s to camelCase
+func camelCase(s string) string {
+	pascal := []rune(pascalCase(s))
+	if len(pascal) == 0 {
+		return ""
+	}
+	pascal[0] = unicode.ToLower(pascal[0])
+	return string(pascal)
+}
@@ -0,0 +1,184 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Version is the templater version named in the headers of generated files
+var Version = "dev"
+
+// provenanceMarker starts the machine-readable provenance line of a generated file header
+const provenanceMarker = "templater:provenance "
+
+// leadingLines start the lines that must stay first in a file, with the header after them
+var leadingLines = []string{"#!", "<?xml", "<?php"}
+
+// commentSyntaxes are the delimiters of line comments by output extension
+var commentSyntaxes = []struct {
+	prefix, suffix string
+	extensions     []string
+}{
+	{"// ", "", []string{".go", ".js", ".mjs", ".ts", ".tsx", ".jsx", ".java", ".kt", ".scala", ".swift",
+		".c", ".h", ".cc", ".cpp", ".hpp", ".cs", ".rs", ".dart", ".proto", ".php", ".scss"}},
+	{"# ", "", []string{".py", ".rb", ".sh", ".bash", ".pl", ".r", ".ps1", ".yaml", ".yml", ".toml",
+		".tf", ".conf", ".env"}},
+	{"; ", "", []string{".ini"}},
+	{"-- ", "", []string{".sql", ".lua", ".hs"}},
+	{"/* ", " */", []string{".css"}},
+	{"<!-- ", " -->", []string{".html", ".xml", ".svg", ".md", ".vue"}},
+}
+
+// Provenance records where a generated file came from. It is written into the file's
+// header by AddHeader and read back by ParseHeader.
+type Provenance struct {
+	// Template and Data are the paths of the template and data files, relative to the project
+	Template string   `json:"template"`
+	Data     []string `json:"data,omitempty"`
+	// Version is the templater version that generated the file
+	Version string `json:"version"`
+	// Sum is the SHA-256 hash of the generated content below the header, leaving out
+	// the content of protected regions
+	Sum string `json:"sum"`
+}
+
+// CommentSyntax returns the delimiters of a line comment in files written to path,
+// or false if the extension has no known comment syntax, as for JSON
+func CommentSyntax(path string) (prefix, suffix string, ok bool) {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, syntax := range commentSyntaxes {
+		if slices.Contains(syntax.extensions, ext) {
+			return syntax.prefix, syntax.suffix, true
+		}
+	}
+	return "", "", false
+}
+
+// AddHeader returns content with a header naming it as generated from the sources in
+// p, in the comment syntax of path: the standard "Code generated ... DO NOT EDIT." line
+// recognized by Go tools and editors, and a provenance line with p and the hash of
+// content. The header follows a leading #! line, XML declaration or <?php tag. Content
+// of files without a known comment syntax, and PHP files not starting in PHP mode, where
+// the header would be output as text, is returned unchanged.
+func AddHeader(path, content string, p Provenance) string {
+	prefix, suffix, ok := CommentSyntax(path)
+	if !ok {
+		return content
+	}
+	if strings.EqualFold(filepath.Ext(path), ".php") && !strings.HasPrefix(content, "<?php") {
+		return content
+	}
+
+	p.Sum = contentSum(content)
+	if p.Version == "" {
+		p.Version = Version
+	}
+	record, _ := json.Marshal(p)
+
+	source := "from " + p.Template
+	if len(p.Data) > 0 {
+		source += " with " + strings.Join(p.Data, ", ")
+	}
+
+	var b strings.Builder
+	first := ""
+	if hasLeadingLine(content) {
+		end := strings.IndexByte(content, '\n') + 1
+		if end == 0 {
+			end = len(content)
+		}
+		first, content = content[:end], content[end:]
+		b.WriteString(first)
+		if !strings.HasSuffix(first, "\n") {
+			b.WriteString("\n")
+		}
+	}
+	fmt.Fprintf(&b, "%sCode generated by templater %s %s. DO NOT EDIT.%s\n", prefix, p.Version, source, suffix)
+	fmt.Fprintf(&b, "%s%s%s%s\n", prefix, provenanceMarker, record, suffix)
+	// Keep the header apart so it is not taken for a doc comment
+	b.WriteString("\n")
+	b.WriteString(content)
+	return b.String()
+}
+
+// ParseHeader returns the provenance recorded in the header added by AddHeader and the
+// content without the header, or nil and content if it has none
+func ParseHeader(content string) (*Provenance, string) {
+	lines := strings.SplitAfter(content, "\n")
+	start := 0
+	if len(lines) > 0 && hasLeadingLine(lines[0]) {
+		start = 1
+	}
+	if len(lines) < start+2 || !strings.Contains(lines[start], "Code generated by templater ") {
+		return nil, content
+	}
+
+	line := strings.TrimSpace(lines[start+1])
+	at := strings.Index(line, provenanceMarker)
+	if at < 0 {
+		return nil, content
+	}
+	record := line[at+len(provenanceMarker):]
+	if end := strings.LastIndexByte(record, '}'); end >= 0 {
+		record = record[:end+1]
+	}
+	var p Provenance
+	if err := json.Unmarshal([]byte(record), &p); err != nil {
+		return nil, content
+	}
+
+	rest := lines[start+2:]
+	if len(rest) > 0 && strings.TrimSpace(rest[0]) == "" {
+		rest = rest[1:]
+	}
+	return &p, strings.Join(lines[:start], "") + strings.Join(rest, "")
+}
+
+// hasLeadingLine reports whether content starts with a line the header must follow
+func hasLeadingLine(content string) bool {
+	for _, prefix := range leadingLines {
+		if strings.HasPrefix(content, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Matches reports whether body, content returned by ParseHeader, is unchanged since it
+// was generated, apart from the content of its protected regions
+func (p *Provenance) Matches(body string) bool {
+	return p.Sum == contentSum(body)
+}
+
+// contentSum returns the hash recorded as Provenance.Sum
+func contentSum(content string) string {
+	sum := sha256.Sum256([]byte(withoutRegionContent(content)))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// withoutRegionContent returns content without the lines inside its protected regions,
+// or content as is if its region markers are mangled
+func withoutRegionContent(content string) string {
+	if !strings.Contains(content, "templater:") {
+		return content
+	}
+	lines := strings.SplitAfter(content, "\n")
+	regions, err := findRegions(lines)
+	if err != nil {
+		return content
+	}
+
+	var b strings.Builder
+	next := 0
+	for _, r := range regions {
+		b.WriteString(strings.Join(lines[next:r.begin+1], ""))
+		next = r.end
+	}
+	b.WriteString(strings.Join(lines[next:], ""))
+	return b.String()
+}
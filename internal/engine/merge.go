@@ -0,0 +1,83 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/singoesdeep/templater/internal/reliability"
+	"github.com/singoesdeep/templater/internal/textdiff"
+)
+
+// Labels of the conflict markers written by merging writes
+const (
+	mergeCurrentLabel   = "current"
+	mergeGeneratedLabel = "generated"
+)
+
+// WriteOptions controls how WriteToFileWithOptions treats an existing file
+type WriteOptions struct {
+	// Format formats the content with the built-in post-processors for the file's
+	// extension, as FormatOutput does
+	Format bool
+	// Merge keeps hand edits made to the file since it was last generated, merging
+	// them with the new content using the last generated version as the base
+	Merge bool
+}
+
+// MergeConflictError reports a file written with conflict markers because hand
+// edits and the newly generated content changed the same lines
+type MergeConflictError struct {
+	Path      string
+	Conflicts int
+}
+
+func (e *MergeConflictError) Error() string {
+	return fmt.Sprintf("%s has %d merge conflict(s) between hand edits and generated content", e.Path, e.Conflicts)
+}
+
+// MergedContent returns what a merging write of generated to path would write, and
+// the number of conflicts it would leave, without writing anything
+func MergedContent(path, generated string) (string, int, error) {
+	return mergeWithGenerated(path, generated)
+}
+
+// mergeWithGenerated returns generated merged with the hand edits made to the file at
+// path since its last generated version, and the number of conflicts. Without an
+// existing file or hand edits, generated is returned as is. Without a stored last
+// generated version, a file that differs from generated is kept whole as one conflict
+// with it, since its hand edits cannot be told apart.
+func mergeWithGenerated(path, generated string) (string, int, error) {
+	base, err := reliability.LoadGenerated(path)
+	if err != nil {
+		return "", 0, err
+	}
+
+	current, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return generated, 0, nil
+	}
+	if err != nil {
+		return "", 0, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if string(current) == generated || (base != nil && string(current) == string(base)) {
+		return generated, 0, nil
+	}
+
+	merged, _ := textdiff.Merge(string(base), string(current), generated, mergeCurrentLabel, mergeGeneratedLabel)
+	// Count conflicts left unresolved since an earlier merge too
+	return merged, countConflicts(merged), nil
+}
+
+// countConflicts returns the number of conflict start markers in content
+func countConflicts(content string) int {
+	count := 0
+	for _, line := range textdiff.SplitLines(content) {
+		if strings.HasPrefix(line, textdiff.ConflictStart+" ") {
+			count++
+		}
+	}
+	return count
+}
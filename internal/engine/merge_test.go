@@ -0,0 +1,183 @@
+package engine
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/singoesdeep/templater/internal/reliability"
+)
+
+func TestCountConflicts(t *testing.T) {
+	tests := []struct {
+		content string
+		want    int
+	}{
+		{"", 0},
+		{"a\nb\n", 0},
+		{"<<<<<<< current\na\n=======\nb\n>>>>>>> generated\n", 1},
+		{"<<<<<<< current\na\n=======\nb\n>>>>>>> generated\nc\n<<<<<<< current\nd\n=======\ne\n>>>>>>> generated\n", 2},
+		// Markers inside lines and without a label are not conflicts
+		{"x <<<<<<< current\n<<<<<<<\n", 0},
+	}
+	for _, tt := range tests {
+		if got := countConflicts(tt.content); got != tt.want {
+			t.Errorf("countConflicts(%q) = %d, want %d", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestMergeWithGenerated(t *testing.T) {
+	tests := []struct {
+		name string
+		// current is the file on disk, "" for none; base is its last generated version, "" for none
+		current, base string
+		generated     string
+		want          string
+		wantConflicts int
+	}{
+		{
+			name:      "no file",
+			base:      "a\n",
+			generated: "b\n",
+			want:      "b\n",
+		},
+		{
+			name:          "no generated version",
+			current:       "a\nedit\n",
+			generated:     "b\n",
+			want:          "<<<<<<< current\na\nedit\n=======\nb\n>>>>>>> generated\n",
+			wantConflicts: 1,
+		},
+		{
+			name:      "no generated version and the file as generated",
+			current:   "a\n",
+			generated: "a\n",
+			want:      "a\n",
+		},
+		{
+			name:      "no hand edits",
+			current:   "a\nb\n",
+			base:      "a\nb\n",
+			generated: "a\nc\n",
+			want:      "a\nc\n",
+		},
+		{
+			name:      "clean merge",
+			current:   "a\nedit\nb\nc\nd\n",
+			base:      "a\nb\nc\nd\n",
+			generated: "a\nb\nc\nD\n",
+			want:      "a\nedit\nb\nc\nD\n",
+		},
+		{
+			name:          "conflict",
+			current:       "a\nedit\nc\n",
+			base:          "a\nb\nc\n",
+			generated:     "a\nB\nc\n",
+			want:          "a\n<<<<<<< current\nedit\n=======\nB\n>>>>>>> generated\nc\n",
+			wantConflicts: 1,
+		},
+		{
+			name:          "unresolved conflict from an earlier merge",
+			current:       "<<<<<<< current\nx\n=======\ny\n>>>>>>> generated\nb\n",
+			base:          "y\nb\n",
+			generated:     "y\nb\nc\n",
+			want:          "<<<<<<< current\nx\n=======\ny\n>>>>>>> generated\nb\nc\n",
+			wantConflicts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "out.txt")
+			if tt.base != "" {
+				if err := os.MkdirAll(filepath.Join(filepath.Dir(path), ".templater_backups"), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(filepath.Dir(path), ".templater_backups", "out.txt.generated"), []byte(tt.base), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.current != "" {
+				if err := os.WriteFile(path, []byte(tt.current), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			got, conflicts, err := mergeWithGenerated(path, tt.generated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want || conflicts != tt.wantConflicts {
+				t.Errorf("mergeWithGenerated = %q with %d conflicts, want %q with %d", got, conflicts, tt.want, tt.wantConflicts)
+			}
+		})
+	}
+}
+
+func TestWriteToFileWithMerge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.txt")
+	write := func(content string) error {
+		return WriteToFileWithOptions(path, content, WriteOptions{Merge: true})
+	}
+	read := func() string {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	if err := write("a\nb\nc\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("a\nedit\nb\nc\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hand edits are kept alongside changes to the generated content
+	if err := write("a\nb\nc\nd\n"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := read(), "a\nedit\nb\nc\nd\n"; got != want {
+		t.Fatalf("after a clean merge the file holds %q, want %q", got, want)
+	}
+
+	// Changing the edited lines leaves conflict markers and reports them
+	err := write("a\nB\nc\nd\n")
+	var conflict *MergeConflictError
+	if !errors.As(err, &conflict) || conflict.Conflicts != 1 {
+		t.Fatalf("write with overlapping changes returned %v, want a MergeConflictError with 1 conflict", err)
+	}
+	if got, want := read(), "a\n<<<<<<< current\nedit\nb\n=======\nB\n>>>>>>> generated\nc\nd\n"; got != want {
+		t.Errorf("after a conflicting merge the file holds %q, want %q", got, want)
+	}
+}
+
+func TestWriteToFileFirstMergeKeepsHandEdits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.txt")
+	// Written without merge, then edited, so no last generated version is stored
+	if err := WriteToFile(path, "a\nb\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("a\nedit\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := WriteToFileWithOptions(path, "a\nb\nc\n", WriteOptions{Merge: true})
+	var conflict *MergeConflictError
+	if !errors.As(err, &conflict) || conflict.Conflicts != 1 {
+		t.Fatalf("first merging write returned %v, want a MergeConflictError with 1 conflict", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(content), "<<<<<<< current\na\nedit\n=======\na\nb\nc\n>>>>>>> generated\n"; got != want {
+		t.Errorf("file holds %q, want the hand-edited file kept in conflict with %q", got, want)
+	}
+	if base, err := reliability.LoadGenerated(path); err != nil || string(base) != "a\nb\nc\n" {
+		t.Errorf("stored last generated version is %q (%v), want the generated content", base, err)
+	}
+}
@@ -0,0 +1,199 @@
+package engine
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/singoesdeep/templater/internal/security"
+)
+
+// OutputDirective describes how a template expands into multiple output files.
+// It is declared in the template metadata, for example:
+//
+//	{{/*
+//	output:
+//	  each: Entities
+//	  path: "models/{{lower .Name}}.go"
+//	*/}}
+type OutputDirective struct {
+	// Each is the data key holding a JSON array of items, one output per item
+	Each string `yaml:"each"`
+	// Path is a template for each output path, relative to the output directory
+	Path string `yaml:"path"`
+}
+
+// RenderedOutput is a single file produced by rendering a template
+type RenderedOutput struct {
+	Path    string
+	Content string
+}
+
+// GetOutputDirective returns the output directive of a template, or nil if it renders to a single file
+func GetOutputDirective(templatePath string) (*OutputDirective, error) {
+	content, err := getFileContent(templatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := ParseTemplateMetadata(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata == nil {
+		return nil, nil
+	}
+
+	return metadata.Output, nil
+}
+
+// RenderOutputs renders a template with an output directive into one output per item.
+// Each item's keys are merged over data, so both are available to the template and path.
+func RenderOutputs(templatePath string, data map[string]string, outputDir string) ([]RenderedOutput, error) {
+	return RenderOutputsWithFuncs(templatePath, data, outputDir, nil)
+}
+
+// RenderOutputsWithFuncs is like RenderOutputs but makes the named template function sets available
+func RenderOutputsWithFuncs(templatePath string, data map[string]string, outputDir string, funcSets []string) ([]RenderedOutput, error) {
+	directive, err := GetOutputDirective(templatePath)
+	if err != nil {
+		return nil, err
+	}
+	if directive == nil {
+		return nil, fmt.Errorf("template %s has no output directive", templatePath)
+	}
+	if directive.Each == "" || directive.Path == "" {
+		return nil, fmt.Errorf("output directive in %s requires both each and path", templatePath)
+	}
+
+	itemsJSON, exists := data[directive.Each]
+	if !exists {
+		return nil, fmt.Errorf("output data not found for key: %s", directive.Each)
+	}
+	items := parseJSONArray(itemsJSON)
+	if items == nil {
+		return nil, fmt.Errorf("output data for key %s is not a JSON array of objects", directive.Each)
+	}
+
+	funcs, err := resolveFuncs(funcSets)
+	if err != nil {
+		return nil, err
+	}
+
+	pathTmpl, err := template.New("output path").Funcs(funcs).Parse(directive.Path)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing output path: %w", err)
+	}
+
+	outputs := make([]RenderedOutput, 0, len(items))
+	seen := make(map[string]bool, len(items))
+	for i, item := range items {
+		itemData := make(map[string]string, len(data)+len(item))
+		for k, v := range data {
+			itemData[k] = v
+		}
+		for k, v := range item {
+			itemData[k] = v
+		}
+
+		var path bytes.Buffer
+		if err := pathTmpl.Execute(&path, itemData); err != nil {
+			return nil, fmt.Errorf("error executing output path for item %d: %w", i, err)
+		}
+
+		// Paths come from data, so keep them inside the output directory
+		relPath := filepath.Clean(path.String())
+		if !filepath.IsLocal(relPath) {
+			return nil, fmt.Errorf("output path %q for item %d is not within the output directory", path.String(), i)
+		}
+		outputPath := filepath.Join(outputDir, relPath)
+		if seen[outputPath] {
+			return nil, fmt.Errorf("duplicate output path %s for item %d", outputPath, i)
+		}
+		seen[outputPath] = true
+
+		result, err := RenderTemplateWithFuncs(templatePath, itemData, funcSets)
+		if err != nil {
+			return nil, fmt.Errorf("error rendering %s: %w", outputPath, err)
+		}
+
+		outputs = append(outputs, RenderedOutput{Path: outputPath, Content: result})
+	}
+
+	return outputs, nil
+}
+
+// WriteOutputs writes rendered outputs, creating parent directories inside outputDir as needed
+func WriteOutputs(outputs []RenderedOutput, outputDir string) error {
+	return WriteOutputsWithOptions(outputs, outputDir, WriteOptions{})
+}
+
+// WriteOutputsWithOptions is like WriteOutputs but writes each output with
+// WriteToFileWithOptions. Outputs with merge conflicts do not stop the others from
+// being written; their errors are returned together.
+func WriteOutputsWithOptions(outputs []RenderedOutput, outputDir string, opts WriteOptions) error {
+	var conflicts []error
+	for _, output := range outputs {
+		// Check against the output directory, not just the file's own directory, before
+		// creating any directory below it. The check needs the output directory to exist.
+		if err := os.MkdirAll(outputDir, 0755); err != nil {
+			return fmt.Errorf("error creating output directory: %w", err)
+		}
+		if err := security.ValidateOutputPath(output.Path, []string{outputDir}); err != nil {
+			return fmt.Errorf("security error: %w", err)
+		}
+		if err := os.MkdirAll(filepath.Dir(output.Path), 0755); err != nil {
+			return fmt.Errorf("error creating output directory: %w", err)
+		}
+
+		err := WriteToFileWithOptions(output.Path, output.Content, opts)
+		var conflict *MergeConflictError
+		if errors.As(err, &conflict) {
+			conflicts = append(conflicts, err)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("error writing %s: %w", output.Path, err)
+		}
+	}
+
+	return errors.Join(conflicts...)
+}
+
+// GenerateOutputs renders a template into its outputs and writes them, returning the written paths.
+// Templates without an output directive are written to defaultOutput.
+func GenerateOutputs(templatePath string, data map[string]string, outputDir, defaultOutput string) ([]string, error) {
+	directive, err := GetOutputDirective(templatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs []RenderedOutput
+	if directive == nil {
+		result, err := RenderTemplate(templatePath, data)
+		if err != nil {
+			return nil, err
+		}
+		outputs = []RenderedOutput{{Path: defaultOutput, Content: result}}
+		outputDir = filepath.Dir(defaultOutput)
+	} else {
+		outputs, err = RenderOutputs(templatePath, data, outputDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := WriteOutputs(outputs, outputDir); err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, len(outputs))
+	for i, output := range outputs {
+		paths[i] = output.Path
+	}
+	return paths, nil
+}
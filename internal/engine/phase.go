@@ -0,0 +1,50 @@
+package engine
+
+import "errors"
+
+// Phase is the step of rendering a template in which an error occurred
+type Phase string
+
+const (
+	// PhaseLoad covers reading the template, its dependencies and their metadata
+	PhaseLoad Phase = "load"
+	// PhaseParse covers parsing the template and its dependencies
+	PhaseParse Phase = "parse"
+	// PhaseValidate covers the security checks on template content
+	PhaseValidate Phase = "validate"
+	// PhaseExecute covers executing the template with its data
+	PhaseExecute Phase = "execute"
+	// PhaseWrite covers writing the result to its output file
+	PhaseWrite Phase = "write"
+)
+
+// PhaseError records the phase in which rendering failed; its message is the underlying error's
+type PhaseError struct {
+	Phase Phase
+	Err   error
+}
+
+func (e *PhaseError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PhaseError) Unwrap() error {
+	return e.Err
+}
+
+// ErrorPhase returns the phase recorded in err, or "" if there is none
+func ErrorPhase(err error) Phase {
+	var phaseErr *PhaseError
+	if errors.As(err, &phaseErr) {
+		return phaseErr.Phase
+	}
+	return ""
+}
+
+// phaseError wraps err with the phase it occurred in, keeping a phase already recorded
+func phaseError(phase Phase, err error) error {
+	if ErrorPhase(err) != "" {
+		return err
+	}
+	return &PhaseError{Phase: phase, Err: err}
+}
@@ -0,0 +1,63 @@
+package engine
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// PostProcessor transforms rendered content before it is written to path
+type PostProcessor func(path string, content string) (string, error)
+
+// postProcessors stores named post-processors that targets can enable
+var postProcessors = struct {
+	sync.RWMutex
+	processors map[string]PostProcessor
+}{
+	processors: map[string]PostProcessor{
+		"gofmt":      formatGo,
+		"json":       formatJSON,
+		"yaml":       validateYAML,
+		"whitespace": normalizeWhitespace,
+	},
+}
+
+// RegisterPostProcessor registers a named post-processor, replacing any with the same name
+func RegisterPostProcessor(name string, processor PostProcessor) {
+	postProcessors.Lock()
+	postProcessors.processors[name] = processor
+	postProcessors.Unlock()
+}
+
+// PostProcessorNames returns the names of the registered post-processors
+func PostProcessorNames() []string {
+	postProcessors.RLock()
+	defer postProcessors.RUnlock()
+
+	names := make([]string, 0, len(postProcessors.processors))
+	for name := range postProcessors.processors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyPostProcessors runs the named post-processors over content in order
+func ApplyPostProcessors(names []string, path string, content string) (string, error) {
+	for _, name := range names {
+		postProcessors.RLock()
+		processor, exists := postProcessors.processors[name]
+		postProcessors.RUnlock()
+		if !exists {
+			return "", fmt.Errorf("unknown post-processor: %s", name)
+		}
+
+		var err error
+		content, err = processor(path, content)
+		if err != nil {
+			return "", fmt.Errorf("post-processor %s failed for %s: %w", name, path, err)
+		}
+	}
+
+	return content, nil
+}
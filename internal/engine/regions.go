@@ -0,0 +1,148 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Protected region markers occupy a line of their own, optionally inside a short
+// comment delimiter such as //, #, <!-- --> or /* */:
+//
+//	// templater:begin custom-imports
+//	// templater:end
+var (
+	regionBeginPattern = regexp.MustCompile(`^\s*\S{0,4}\s*templater:begin\s+([\w.-]+)\s*\S{0,4}\s*$`)
+	regionEndPattern   = regexp.MustCompile(`^\s*\S{0,4}\s*templater:end(?:\s+([\w.-]+))?\s*\S{0,4}\s*$`)
+)
+
+// RegionError reports mangled protected region markers, such as a begin marker
+// without an end marker
+type RegionError struct {
+	Line    int
+	Message string
+}
+
+func (e *RegionError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+}
+
+// region is a protected region, located by the line indexes of its markers
+type region struct {
+	name       string
+	begin, end int
+}
+
+// findRegions returns the protected regions of lines in order
+func findRegions(lines []string) ([]region, error) {
+	var regions []region
+	seen := make(map[string]int)
+	var open *region
+
+	for i, line := range lines {
+		text := strings.TrimRight(line, "\r\n")
+		if match := regionBeginPattern.FindStringSubmatch(text); match != nil {
+			if open != nil {
+				return nil, &RegionError{Line: i + 1, Message: fmt.Sprintf("region %q begins inside region %q", match[1], open.name)}
+			}
+			if first, exists := seen[match[1]]; exists {
+				return nil, &RegionError{Line: i + 1, Message: fmt.Sprintf("region %q is already defined on line %d", match[1], first)}
+			}
+			seen[match[1]] = i + 1
+			open = &region{name: match[1], begin: i}
+			continue
+		}
+		if match := regionEndPattern.FindStringSubmatch(text); match != nil {
+			if open == nil {
+				return nil, &RegionError{Line: i + 1, Message: "end marker without a begin marker"}
+			}
+			if match[1] != "" && match[1] != open.name {
+				return nil, &RegionError{Line: i + 1, Message: fmt.Sprintf("end marker for %q closes region %q", match[1], open.name)}
+			}
+			open.end = i
+			regions = append(regions, *open)
+			open = nil
+		}
+	}
+
+	if open != nil {
+		return nil, &RegionError{Line: open.begin + 1, Message: fmt.Sprintf("region %q has no end marker", open.name)}
+	}
+	return regions, nil
+}
+
+// PreserveRegions returns generated with the content of each protected region replaced
+// by the content of the region of the same name in existing, keeping the markers of
+// generated. Regions of existing that generated no longer has are returned as orphaned;
+// their content is dropped. Mangled markers in either text are reported as a *RegionError.
+func PreserveRegions(generated, existing string) (string, []string, error) {
+	if !strings.Contains(generated, "templater:") && !strings.Contains(existing, "templater:") {
+		return generated, nil, nil
+	}
+
+	genLines := strings.SplitAfter(generated, "\n")
+	genRegions, err := findRegions(genLines)
+	if err != nil {
+		return "", nil, fmt.Errorf("generated content: %w", err)
+	}
+	oldLines := strings.SplitAfter(existing, "\n")
+	oldRegions, err := findRegions(oldLines)
+	if err != nil {
+		return "", nil, fmt.Errorf("existing file: %w", err)
+	}
+
+	previous := make(map[string]region, len(oldRegions))
+	for _, r := range oldRegions {
+		previous[r.name] = r
+	}
+
+	var b strings.Builder
+	next := 0
+	for _, r := range genRegions {
+		old, exists := previous[r.name]
+		if !exists {
+			continue
+		}
+		delete(previous, r.name)
+
+		for _, line := range genLines[next : r.begin+1] {
+			b.WriteString(line)
+		}
+		for _, line := range oldLines[old.begin+1 : old.end] {
+			b.WriteString(line)
+		}
+		next = r.end
+	}
+	for _, line := range genLines[next:] {
+		b.WriteString(line)
+	}
+
+	var orphaned []string
+	for _, r := range oldRegions {
+		if _, dropped := previous[r.name]; dropped {
+			orphaned = append(orphaned, r.name)
+		}
+	}
+	return b.String(), orphaned, nil
+}
+
+// PreserveFileRegions carries the protected regions of the file at path over into
+// generated, as PreserveRegions does. A missing file leaves generated unchanged.
+func PreserveFileRegions(path, generated string) (string, []string, error) {
+	existing, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return generated, nil, nil
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("error reading %s: %w", path, err)
+	}
+
+	merged, orphaned, err := PreserveRegions(generated, string(existing))
+	if err != nil {
+		return "", nil, fmt.Errorf("protected regions of %s: %w", path, err)
+	}
+	return merged, orphaned, nil
+}
@@ -6,15 +6,19 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"text/template"
 	"time"
 	"unicode"
 
 	"github.com/singoesdeep/templater/internal/reliability"
 	"github.com/singoesdeep/templater/internal/security"
+	"github.com/singoesdeep/templater/internal/ui"
 	"gopkg.in/yaml.v3"
 )
 
@@ -45,14 +49,18 @@ var TemplateFuncs = template.FuncMap{
 	"join":  strings.Join,
 }
 
+// metadataKeyPattern matches a line setting a TemplateMetadata field
+var metadataKeyPattern = regexp.MustCompile(`^(name|description|author|version|depends_on|required_keys|output)\s*:`)
+
 // TemplateMetadata represents metadata about a template
 type TemplateMetadata struct {
-	Name         string   `yaml:"name"`
-	Description  string   `yaml:"description"`
-	Author       string   `yaml:"author"`
-	Version      string   `yaml:"version"`
-	DependsOn    []string `yaml:"depends_on"`
-	RequiredKeys []string `yaml:"required_keys"`
+	Name         string           `yaml:"name"`
+	Description  string           `yaml:"description"`
+	Author       string           `yaml:"author"`
+	Version      string           `yaml:"version"`
+	DependsOn    []string         `yaml:"depends_on"`
+	RequiredKeys []string         `yaml:"required_keys"`
+	Output       *OutputDirective `yaml:"output"`
 }
 
 // ParseTemplateMetadata extracts metadata from template comments
@@ -72,15 +80,38 @@ func ParseTemplateMetadata(content string) (*TemplateMetadata, error) {
 	}
 
 	metadataContent := content[startIdx+len(metadataStart) : startIdx+endIdx]
-	metadata := &TemplateMetadata{}
 
-	if err := yaml.Unmarshal([]byte(metadataContent), metadata); err != nil {
+	// Only a YAML mapping is metadata; any other comment is ordinary text
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(metadataContent), &doc); err != nil {
+		if looksLikeMetadata(metadataContent) {
+			return nil, fmt.Errorf("error parsing template metadata: %w", err)
+		}
+		return nil, nil
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, nil
+	}
+
+	metadata := &TemplateMetadata{}
+	if err := doc.Decode(metadata); err != nil {
 		return nil, fmt.Errorf("error parsing template metadata: %w", err)
 	}
 
 	return metadata, nil
 }
 
+// looksLikeMetadata reports whether a comment sets a metadata field, so that it was
+// meant as metadata even if it is not valid YAML
+func looksLikeMetadata(comment string) bool {
+	for _, line := range strings.Split(comment, "\n") {
+		if metadataKeyPattern.MatchString(strings.TrimSpace(line)) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetTemplateDependencies returns a list of template dependencies
 func GetTemplateDependencies(templatePath string) ([]string, error) {
 	content, err := os.ReadFile(templatePath)
@@ -100,9 +131,10 @@ func GetTemplateDependencies(templatePath string) ([]string, error) {
 	return metadata.DependsOn, nil
 }
 
-// ValidateTemplateDependencies checks if all required template dependencies exist
+// ValidateTemplateDependencies checks if all required template dependencies exist,
+// resolving relative paths against the template's directory
 func ValidateTemplateDependencies(templatePath string) error {
-	deps, err := GetTemplateDependencies(templatePath)
+	deps, err := ResolveTemplateDependencies(templatePath)
 	if err != nil {
 		return err
 	}
@@ -131,6 +163,9 @@ var (
 	// maxCacheSize limits the number of cached templates
 	maxCacheSize = 100
 
+	// cacheHits and cacheMisses count template cache lookups
+	cacheHits, cacheMisses atomic.Uint64
+
 	// fileContentCache stores file contents to reduce I/O
 	fileContentCache = struct {
 		sync.RWMutex
@@ -145,6 +180,21 @@ type templateInfo struct {
 	template     *template.Template
 	lastUsed     time.Time
 	lastModified time.Time
+	// depsModified holds the modification times of the depends_on files parsed with the template
+	depsModified map[string]time.Time
+	// sets are the function sets the template was parsed with
+	sets []string
+}
+
+// depsChanged reports whether any dependency parsed with the template changed on disk
+func (i *templateInfo) depsChanged() bool {
+	for path, modified := range i.depsModified {
+		fileInfo, err := os.Stat(path)
+		if err != nil || !fileInfo.ModTime().Equal(modified) {
+			return true
+		}
+	}
+	return false
 }
 
 // fileContent stores file content and metadata
@@ -212,37 +262,80 @@ func getFileContent(path string) ([]byte, error) {
 
 // GetCachedTemplate retrieves a template from cache or parses a new one
 func GetCachedTemplate(templatePath string) (*template.Template, error) {
+	return getCachedTemplate(templatePath, nil)
+}
+
+// getCachedTemplate retrieves a template parsed with the named function sets from cache or parses a new one
+func getCachedTemplate(templatePath string, sets []string) (*template.Template, error) {
+	// Templates parsed with different function sets are cached separately
+	cacheKey := templatePath
+	if len(sets) > 0 {
+		cacheKey += "|" + strings.Join(sets, ",")
+	}
+
 	// Check cache first
 	templateCache.RLock()
-	if info, exists := templateCache.templates[templatePath]; exists {
-		// Check if template is still valid
-		if time.Since(info.lastUsed) < cacheExpiry {
+	if info, exists := templateCache.templates[cacheKey]; exists {
+		// Check if template is still valid and neither it nor its dependencies changed on disk
+		fileInfo, err := os.Stat(templatePath)
+		if err == nil && fileInfo.ModTime().Equal(info.lastModified) && time.Since(info.lastUsed) < cacheExpiry && !info.depsChanged() {
 			// Update last used time
 			info.lastUsed = time.Now()
 			templateCache.RUnlock()
+			cacheHits.Add(1)
 			return info.template, nil
 		}
 	}
 	templateCache.RUnlock()
+	cacheMisses.Add(1)
 
 	// Get template content from cache or file
 	tmplContent, err := getFileContent(templatePath)
 	if err != nil {
-		return nil, err
+		return nil, phaseError(PhaseLoad, err)
 	}
 
 	// Get file modification time
 	fileInfo, err := os.Stat(templatePath)
 	if err != nil {
-		return nil, fmt.Errorf("error getting file info: %w", err)
+		return nil, phaseError(PhaseLoad, fmt.Errorf("error getting file info: %w", err))
 	}
 
-	// Parse template with functions
-	tmpl, err := template.New(filepath.Base(templatePath)).
-		Funcs(TemplateFuncs).
-		Parse(string(tmplContent))
+	funcs, err := resolveFuncs(sets)
 	if err != nil {
-		return nil, fmt.Errorf("error parsing template: %w", err)
+		return nil, phaseError(PhaseParse, err)
+	}
+
+	// Parse the depends_on files first so the template can invoke what they
+	// define with {{template}} and its own definitions take precedence
+	tmpl := template.New(filepath.Base(templatePath)).Funcs(funcs)
+	deps, err := dependencyClosure(templatePath)
+	if err != nil {
+		return nil, phaseError(PhaseLoad, err)
+	}
+	depsModified := make(map[string]time.Time, len(deps))
+	for _, dep := range deps {
+		depContent, err := getFileContent(dep)
+		if err != nil {
+			return nil, phaseError(PhaseLoad, fmt.Errorf("error loading template dependency: %w", err))
+		}
+		if err := security.ValidateTemplateContent(string(depContent)); err != nil {
+			return nil, phaseError(PhaseValidate, fmt.Errorf("template validation error in %s: %w", dep, err))
+		}
+		depInfo, err := os.Stat(dep)
+		if err != nil {
+			return nil, phaseError(PhaseLoad, fmt.Errorf("error getting file info: %w", err))
+		}
+		depsModified[dep] = depInfo.ModTime()
+
+		if _, err := tmpl.New(dep).Parse(string(depContent)); err != nil {
+			return nil, phaseError(PhaseParse, fmt.Errorf("error parsing template dependency %s: %w", dep, err))
+		}
+	}
+
+	// Parse template with functions
+	if _, err := tmpl.Parse(string(tmplContent)); err != nil {
+		return nil, phaseError(PhaseParse, fmt.Errorf("error parsing template: %w", err))
 	}
 
 	// Update cache
@@ -261,16 +354,29 @@ func GetCachedTemplate(templatePath string) (*template.Template, error) {
 			delete(templateCache.templates, oldestPath)
 		}
 	}
-	templateCache.templates[templatePath] = &templateInfo{
+	templateCache.templates[cacheKey] = &templateInfo{
 		template:     tmpl,
 		lastUsed:     time.Now(),
 		lastModified: fileInfo.ModTime(),
+		depsModified: depsModified,
+		sets:         sets,
 	}
 	templateCache.Unlock()
 
 	return tmpl, nil
 }
 
+// CacheStats counts the template cache lookups since the program started
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+// TemplateCacheStats returns the template cache lookup counts
+func TemplateCacheStats() CacheStats {
+	return CacheStats{Hits: cacheHits.Load(), Misses: cacheMisses.Load()}
+}
+
 // CleanupCache removes expired templates and file contents
 func CleanupCache() {
 	now := time.Now()
@@ -299,22 +405,29 @@ func CleanupCache() {
 
 // RenderTemplate processes a template file with the given data
 func RenderTemplate(templatePath string, data map[string]string) (string, error) {
+	return RenderTemplateWithFuncs(templatePath, data, nil)
+}
+
+// RenderTemplateWithFuncs processes a template file with the given data,
+// making the named template function sets available in addition to TemplateFuncs.
+// Errors are *PhaseError values recording the phase that failed.
+func RenderTemplateWithFuncs(templatePath string, data map[string]string, funcSets []string) (string, error) {
 	// Get template content from cache or file
 	tmplContent, err := getFileContent(templatePath)
 	if err != nil {
-		return "", err
+		return "", phaseError(PhaseLoad, err)
 	}
 
 	// Validate template content
 	if err := security.ValidateTemplateContent(string(tmplContent)); err != nil {
-		return "", fmt.Errorf("template validation error: %w", err)
+		return "", phaseError(PhaseValidate, fmt.Errorf("template validation error: %w", err))
 	}
 
 	// Sanitize data
 	data = security.SanitizeData(data)
 
 	// Get template from cache or parse new
-	tmpl, err := GetCachedTemplate(templatePath)
+	tmpl, err := getCachedTemplate(templatePath, funcSets)
 	if err != nil {
 		return "", err
 	}
@@ -322,13 +435,13 @@ func RenderTemplate(templatePath string, data map[string]string) (string, error)
 	// Execute template
 	var result bytes.Buffer
 	if err := tmpl.Execute(&result, data); err != nil {
-		return "", fmt.Errorf("error executing template: %w", err)
+		return "", phaseError(PhaseExecute, fmt.Errorf("error executing template: %w", err))
 	}
 
 	return result.String(), nil
 }
 
-// LoadData loads data from a JSON or YAML file
+// LoadData loads data from a JSON or YAML file, or from a file type with a registered data loader
 func LoadData(dataPath string) (map[string]string, error) {
 	if dataPath == "" {
 		return make(map[string]string), nil
@@ -340,6 +453,14 @@ func LoadData(dataPath string) (map[string]string, error) {
 	}
 
 	ext := strings.ToLower(filepath.Ext(dataPath))
+	if loader := dataLoader(ext); loader != nil {
+		result, err := loader(dataPath, data)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing %s data: %w", ext, err)
+		}
+		return result, nil
+	}
+
 	var result map[string]string
 	if ext == ".yaml" || ext == ".yml" {
 		if err := yaml.Unmarshal(data, &result); err != nil {
@@ -354,8 +475,19 @@ func LoadData(dataPath string) (map[string]string, error) {
 	return result, nil
 }
 
-// WriteToFile writes the content to a file with security and reliability checks
+// WriteToFile writes the content to a file with security and reliability checks.
+// The content of protected regions in the existing file is carried over; mangled
+// region markers leave the file untouched.
 func WriteToFile(filePath string, content string) error {
+	return WriteToFileWithOptions(filePath, content, WriteOptions{})
+}
+
+// WriteToFileWithOptions is like WriteToFile, and with opts.Format formats content
+// with the built-in post-processors for the file's extension, failing on syntax
+// errors without touching the file. With opts.Merge, hand edits of the existing file
+// are merged into content; conflicting changes are written with conflict markers and
+// reported as a *MergeConflictError.
+func WriteToFileWithOptions(filePath string, content string, opts WriteOptions) error {
 	// Validate output path
 	allowedDirs := []string{
 		filepath.Dir(filePath),
@@ -364,6 +496,32 @@ func WriteToFile(filePath string, content string) error {
 		return fmt.Errorf("security error: %w", err)
 	}
 
+	if opts.Format {
+		formatted, err := FormatOutput(filePath, content)
+		if err != nil {
+			return fmt.Errorf("format error: %w", err)
+		}
+		content = formatted
+	}
+
+	// Keep what was written inside protected regions
+	content, orphaned, err := PreserveFileRegions(filePath, content)
+	if err != nil {
+		return fmt.Errorf("region error: %w", err)
+	}
+	for _, name := range orphaned {
+		ui.PrintWarning("protected region %q is no longer generated in %s; its content is kept in the backup", name, filePath)
+	}
+
+	generated := content
+	conflicts := 0
+	if opts.Merge {
+		content, conflicts, err = mergeWithGenerated(filePath, content)
+		if err != nil {
+			return fmt.Errorf("merge error: %w", err)
+		}
+	}
+
 	// Create backup if file exists
 	if err := reliability.BackupFile(filePath); err != nil {
 		return fmt.Errorf("backup error: %w", err)
@@ -393,6 +551,15 @@ func WriteToFile(filePath string, content string) error {
 		fmt.Printf("Warning: Failed to cleanup old backups: %v\n", err)
 	}
 
+	if opts.Merge {
+		if err := reliability.SaveGenerated(filePath, []byte(generated)); err != nil {
+			return fmt.Errorf("merge error: %w", err)
+		}
+		if conflicts > 0 {
+			return &MergeConflictError{Path: filePath, Conflicts: conflicts}
+		}
+	}
+
 	return nil
 }
 
@@ -440,6 +607,17 @@ func ValidateTemplateData(templatePath string, data map[string]string) error {
 	return nil
 }
 
+// evictTemplatesUsing removes the cached templates parsed with the named function set
+func evictTemplatesUsing(set string) {
+	templateCache.Lock()
+	defer templateCache.Unlock()
+	for key, info := range templateCache.templates {
+		if slices.Contains(info.sets, set) {
+			delete(templateCache.templates, key)
+		}
+	}
+}
+
 // ClearTemplateCache clears the template cache
 func ClearTemplateCache() {
 	templateCache = struct {
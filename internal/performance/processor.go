@@ -1,34 +1,89 @@
 package performance
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/singoesdeep/templater/internal/engine"
 )
 
-// ProcessingStats tracks performance metrics
-type ProcessingStats struct {
-	StartTime      time.Time
-	EndTime        time.Time
-	TemplateCount  int
-	ErrorCount     int
-	MemoryUsage    uint64
-	ProcessingTime time.Duration
-	WorkerCount    int
-	CacheHits      int
-	CacheMisses    int
-}
-
-// ConcurrentProcessor handles parallel template processing
+// ConcurrentProcessor handles parallel template processing. A processor can be
+// reused for any number of runs, including concurrent ones. The zero value is ready
+// to use with a single worker.
 type ConcurrentProcessor struct {
 	MaxWorkers int
-	Stats      *ProcessingStats
-	mu         sync.RWMutex
-	workerPool chan struct{}
-	stopChan   chan struct{}
+	// Stats are the stats of the last completed run; use GetStats to read them
+	// while runs may be in progress
+	Stats *ProcessingStats
+	mu    sync.RWMutex
+	// cancels cancels the runs in progress, for Stop
+	cancels map[*context.CancelFunc]struct{}
+}
+
+// Job is a template rendered with its own data. When Output is set the result is
+// written straight to that file instead of being returned.
+type Job struct {
+	Template string
+	Data     map[string]string
+	Output   string
+}
+
+// Result is the outcome of a job
+type Result struct {
+	// Index is the position of the job in the list processed
+	Index int
+	Job   Job
+	// Content is the rendered result for jobs without an Output
+	Content string
+	// Err is a *JobError for failed jobs, or the context's error for jobs never started
+	Err error
+}
+
+// JobError reports the failure of a job and the phase it failed in
+type JobError struct {
+	Template string
+	Output   string
+	Phase    engine.Phase
+	Err      error
+
+	// index is the position of the job, for ordering
+	index int
+}
+
+func (e *JobError) Error() string {
+	return fmt.Sprintf("%s: %s failed: %v", e.Template, e.Phase, e.Err)
+}
+
+func (e *JobError) Unwrap() error {
+	return e.Err
+}
+
+// ProcessingError collects the failed jobs of a run
+type ProcessingError struct {
+	Errors []*JobError
+}
+
+func (e *ProcessingError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("processing errors: %d failed: %s", len(e.Errors), strings.Join(messages, "; "))
+}
+
+// Unwrap returns the job errors, for errors.Is and errors.As
+func (e *ProcessingError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err
+	}
+	return errs
 }
 
 // NewConcurrentProcessor creates a new processor with optimal worker count
@@ -41,16 +96,13 @@ func NewConcurrentProcessor() *ConcurrentProcessor {
 	}
 	return &ConcurrentProcessor{
 		MaxWorkers: numCPU,
-		Stats: &ProcessingStats{
-			StartTime:   time.Now(),
-			WorkerCount: numCPU,
-		},
-		workerPool: make(chan struct{}, numCPU),
-		stopChan:   make(chan struct{}),
+		Stats:      &ProcessingStats{WorkerCount: numCPU},
+		cancels:    make(map[*context.CancelFunc]struct{}),
 	}
 }
 
-// SetMaxWorkers adjusts the maximum number of concurrent workers
+// SetMaxWorkers adjusts the maximum number of concurrent workers. Runs in progress
+// keep their worker count; the new count applies from the next run.
 func (p *ConcurrentProcessor) SetMaxWorkers(count int) {
 	if count < 1 {
 		count = 1
@@ -60,101 +112,213 @@ func (p *ConcurrentProcessor) SetMaxWorkers(count int) {
 	}
 	p.mu.Lock()
 	p.MaxWorkers = count
-	p.workerPool = make(chan struct{}, count)
-	p.Stats.WorkerCount = count
 	p.mu.Unlock()
 }
 
-// ProcessTemplates concurrently processes multiple templates
-func (p *ConcurrentProcessor) ProcessTemplates(templates []string, data map[string]string) (map[string]string, error) {
-	results := make(map[string]string, len(templates))
-	var wg sync.WaitGroup
-	errors := make(chan error, len(templates))
-	resultChan := make(chan struct {
-		path   string
-		result string
-	}, len(templates))
-
-	// Process templates concurrently
-	for _, tmpl := range templates {
-		select {
-		case <-p.stopChan:
-			return results, fmt.Errorf("processing stopped")
-		case p.workerPool <- struct{}{}: // Acquire worker slot
-			wg.Add(1)
-			go func(templatePath string) {
-				defer wg.Done()
-				defer func() { <-p.workerPool }() // Release worker slot
-
-				// Process template
-				result, err := engine.RenderTemplate(templatePath, data)
-				if err != nil {
-					errors <- fmt.Errorf("error processing %s: %w", templatePath, err)
-					p.mu.Lock()
-					p.Stats.ErrorCount++
-					p.mu.Unlock()
-					return
-				}
-
-				// Send result through channel
-				resultChan <- struct {
-					path   string
-					result string
-				}{templatePath, result}
-
-				// Update stats
-				p.mu.Lock()
-				p.Stats.TemplateCount++
-				p.mu.Unlock()
-			}(tmpl)
-		}
+// Process renders the jobs concurrently until all are done or ctx is cancelled, and
+// returns one result per job in the order given. Failed jobs are reported together as
+// a *ProcessingError.
+func (p *ConcurrentProcessor) Process(ctx context.Context, jobs []Job) ([]Result, error) {
+	results := make([]Result, len(jobs))
+	err := p.ProcessFunc(ctx, jobs, func(result Result) {
+		results[result.Index] = result
+	})
+	return results, err
+}
+
+// Stream renders the jobs concurrently and delivers each result as soon as it completes,
+// so results need not be held in memory. The channel is closed once every job has a
+// result; after ctx is cancelled, remaining results are dropped if not received.
+// The returned function waits for the run to complete and returns its error, as
+// ProcessFunc does; call it once the channel is drained or ctx is cancelled.
+func (p *ConcurrentProcessor) Stream(ctx context.Context, jobs []Job) (<-chan Result, func() error) {
+	out := make(chan Result)
+	done := make(chan struct{})
+	var err error
+	go func() {
+		defer close(out)
+		err = p.ProcessFunc(ctx, jobs, func(result Result) {
+			select {
+			case out <- result:
+			case <-ctx.Done():
+			}
+		})
+		close(done)
+	}()
+	return out, func() error {
+		<-done
+		return err
+	}
+}
+
+// ProcessFunc renders the jobs concurrently and calls handle with each result as it
+// completes, one call at a time. Jobs not started before ctx is cancelled get a result
+// carrying the context's error, and the run returns it. Otherwise failed jobs are
+// returned together as a *ProcessingError. Once the run completes, its stats are
+// returned by GetStats.
+func (p *ConcurrentProcessor) ProcessFunc(ctx context.Context, jobs []Job, handle func(Result)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	p.mu.Lock()
+	workers := max(p.MaxWorkers, 1)
+	if p.cancels == nil {
+		p.cancels = make(map[*context.CancelFunc]struct{})
 	}
+	p.cancels[&cancel] = struct{}{}
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.cancels, &cancel)
+		p.mu.Unlock()
+	}()
 
-	// Wait for all templates to be processed
-	wg.Wait()
-	close(errors)
-	close(resultChan)
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+	run := startRun(workers)
+
+	indexes := make(chan int)
+	results := make(chan jobResult)
+	var wg sync.WaitGroup
 
-	// Collect results
-	for result := range resultChan {
-		results[result.path] = result.result
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results <- p.processJob(ctx, i, jobs[i])
+			}
+		}()
 	}
 
-	// Collect errors
-	var errs []error
-	for err := range errors {
-		errs = append(errs, err)
+	// Hand out jobs until all are taken or the run is cancelled, then report the rest
+	go func() {
+		next := 0
+	feed:
+		for ; next < len(jobs); next++ {
+			select {
+			case indexes <- next:
+			case <-ctx.Done():
+				break feed
+			}
+		}
+		close(indexes)
+		wg.Wait()
+
+		for ; next < len(jobs); next++ {
+			results <- jobResult{Result: Result{Index: next, Job: jobs[next], Err: ctx.Err()}}
+		}
+		close(results)
+	}()
+
+	var failed []*JobError
+	for result := range results {
+		var jobErr *JobError
+		if errors.As(result.Err, &jobErr) {
+			failed = append(failed, jobErr)
+		}
+		if result.started {
+			run.record(result.timing)
+		}
+		handle(result.Result)
 	}
 
-	// Update stats
+	stats := run.finish()
 	p.mu.Lock()
-	p.Stats.EndTime = time.Now()
-	p.Stats.ProcessingTime = p.Stats.EndTime.Sub(p.Stats.StartTime)
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-	p.Stats.MemoryUsage = m.Alloc
+	p.Stats = &stats
 	p.mu.Unlock()
 
 	// Trigger garbage collection
 	runtime.GC()
 
-	if len(errs) > 0 {
-		return results, fmt.Errorf("processing errors: %v", errs)
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("processing stopped: %w", err)
 	}
+	if len(failed) > 0 {
+		// Report failures in job order, whatever order they completed in
+		sort.Slice(failed, func(i, j int) bool { return failed[i].index < failed[j].index })
+		return &ProcessingError{Errors: failed}
+	}
+
+	return nil
+}
 
-	return results, nil
+// jobResult is a result with how its job went, for the run's stats
+type jobResult struct {
+	Result
+	started bool
+	timing  TemplateTiming
 }
 
-// Stop stops the processor and cancels any ongoing operations
+// processJob renders a job and writes its output, unless the run was cancelled first
+func (p *ConcurrentProcessor) processJob(ctx context.Context, index int, job Job) jobResult {
+	if err := ctx.Err(); err != nil {
+		return jobResult{Result: Result{Index: index, Job: job, Err: err}}
+	}
+
+	start := time.Now()
+	timing := TemplateTiming{Template: job.Template, Output: job.Output, index: index}
+
+	content, err := engine.RenderTemplate(job.Template, job.Data)
+	phase := engine.ErrorPhase(err)
+	if err == nil && job.Output != "" {
+		// Written outputs are not kept in memory
+		err = engine.WriteToFile(job.Output, content)
+		phase = engine.PhaseWrite
+		timing.Bytes = int64(len(content))
+		content = ""
+	}
+	timing.Duration = time.Since(start)
+
+	if err != nil {
+		if phase == "" {
+			phase = engine.PhaseLoad
+		}
+		timing.Bytes = 0
+		timing.Failed = true
+		jobErr := &JobError{Template: job.Template, Output: job.Output, Phase: phase, Err: err, index: index}
+		return jobResult{Result: Result{Index: index, Job: job, Err: jobErr}, started: true, timing: timing}
+	}
+
+	return jobResult{Result: Result{Index: index, Job: job, Content: content}, started: true, timing: timing}
+}
+
+// ProcessTemplates concurrently processes multiple templates with the same data,
+// returning the results keyed by template path
+func (p *ConcurrentProcessor) ProcessTemplates(templates []string, data map[string]string) (map[string]string, error) {
+	jobs := make([]Job, len(templates))
+	for i, tmpl := range templates {
+		jobs[i] = Job{Template: tmpl, Data: data}
+	}
+
+	processed, err := p.Process(context.Background(), jobs)
+
+	results := make(map[string]string, len(templates))
+	for _, result := range processed {
+		if result.Err == nil {
+			results[result.Job.Template] = result.Content
+		}
+	}
+	return results, err
+}
+
+// Stop cancels the runs in progress. The processor stays usable and calling Stop again is safe.
 func (p *ConcurrentProcessor) Stop() {
-	close(p.stopChan)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for cancel := range p.cancels {
+		(*cancel)()
+	}
 }
 
-// GetStats returns the current processing statistics
+// GetStats returns a copy of the stats of the last completed run. When runs overlap,
+// it is the one that finished last.
 func (p *ConcurrentProcessor) GetStats() *ProcessingStats {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
-	return p.Stats
+	return copyStats(p.Stats)
 }
 
 // OptimizeMemory triggers garbage collection and memory optimization
@@ -169,20 +333,3 @@ func OptimizeMemory() {
 		runtime.GC()
 	}
 }
-
-// MonitorResources starts resource monitoring at specified intervals
-func MonitorResources(interval time.Duration, callback func(*ProcessingStats)) {
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
-
-	for range ticker.C {
-		var m runtime.MemStats
-		runtime.ReadMemStats(&m)
-
-		stats := &ProcessingStats{
-			MemoryUsage: m.Alloc,
-		}
-
-		callback(stats)
-	}
-}
@@ -0,0 +1,302 @@
+package performance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/singoesdeep/templater/internal/engine"
+)
+
+// ProcessingStats are the performance metrics of one processing run
+type ProcessingStats struct {
+	StartTime     time.Time
+	EndTime       time.Time
+	TemplateCount int
+	ErrorCount    int
+	// MemoryUsage is the memory allocated at the end of the run, PeakMemory the most
+	// sampled while it ran
+	MemoryUsage    uint64
+	PeakMemory     uint64
+	ProcessingTime time.Duration
+	WorkerCount    int
+	// CacheHits and CacheMisses count the template cache lookups made while the run was
+	// in progress, including those of other renders running at the same time
+	CacheHits    int
+	CacheMisses  int
+	BytesWritten int64
+	// Timings summarises the render times of the jobs started, Templates lists them
+	Timings   TimingSummary
+	Templates []TemplateTiming
+}
+
+// TimingSummary summarises a set of durations
+type TimingSummary struct {
+	P50 time.Duration
+	P95 time.Duration
+	Max time.Duration
+}
+
+// TemplateTiming is how a job of a run went
+type TemplateTiming struct {
+	Template string
+	Output   string
+	Duration time.Duration
+	Bytes    int64
+	Failed   bool
+
+	// index is the position of the job, for ordering
+	index int
+}
+
+// CacheHitRatio returns the share of template cache lookups that were hits, or 0
+// when there were none
+func (s *ProcessingStats) CacheHitRatio() float64 {
+	lookups := s.CacheHits + s.CacheMisses
+	if lookups == 0 {
+		return 0
+	}
+	return float64(s.CacheHits) / float64(lookups)
+}
+
+// milliseconds converts a duration for reports
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// timingReport is a job in the JSON report
+type timingReport struct {
+	Template   string  `json:"template"`
+	Output     string  `json:"output,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+	Bytes      int64   `json:"bytes"`
+	Failed     bool    `json:"failed,omitempty"`
+}
+
+// statsReport is the JSON report of a run, with durations in milliseconds
+type statsReport struct {
+	StartTime        time.Time `json:"start_time"`
+	EndTime          time.Time `json:"end_time"`
+	ProcessingTimeMs float64   `json:"processing_time_ms"`
+	Workers          int       `json:"workers"`
+	Templates        int       `json:"templates"`
+	Errors           int       `json:"errors"`
+	BytesWritten     int64     `json:"bytes_written"`
+	Timings          struct {
+		P50Ms float64 `json:"p50_ms"`
+		P95Ms float64 `json:"p95_ms"`
+		MaxMs float64 `json:"max_ms"`
+	} `json:"timings"`
+	Cache struct {
+		Hits     int     `json:"hits"`
+		Misses   int     `json:"misses"`
+		HitRatio float64 `json:"hit_ratio"`
+	} `json:"cache"`
+	Memory struct {
+		EndBytes  uint64 `json:"end_bytes"`
+		PeakBytes uint64 `json:"peak_bytes"`
+	} `json:"memory"`
+	PerTemplate []timingReport `json:"per_template"`
+}
+
+// MarshalJSON encodes the stats as the report printed by --stats json
+func (s *ProcessingStats) MarshalJSON() ([]byte, error) {
+	report := statsReport{
+		StartTime:        s.StartTime,
+		EndTime:          s.EndTime,
+		ProcessingTimeMs: milliseconds(s.ProcessingTime),
+		Workers:          s.WorkerCount,
+		Templates:        s.TemplateCount,
+		Errors:           s.ErrorCount,
+		BytesWritten:     s.BytesWritten,
+		PerTemplate:      make([]timingReport, len(s.Templates)),
+	}
+	report.Timings.P50Ms = milliseconds(s.Timings.P50)
+	report.Timings.P95Ms = milliseconds(s.Timings.P95)
+	report.Timings.MaxMs = milliseconds(s.Timings.Max)
+	report.Cache.Hits = s.CacheHits
+	report.Cache.Misses = s.CacheMisses
+	report.Cache.HitRatio = s.CacheHitRatio()
+	report.Memory.EndBytes = s.MemoryUsage
+	report.Memory.PeakBytes = s.PeakMemory
+	for i, t := range s.Templates {
+		report.PerTemplate[i] = timingReport{
+			Template:   t.Template,
+			Output:     t.Output,
+			DurationMs: milliseconds(t.Duration),
+			Bytes:      t.Bytes,
+			Failed:     t.Failed,
+		}
+	}
+
+	return json.Marshal(report)
+}
+
+// WriteText writes a human readable summary of the stats
+func (s *ProcessingStats) WriteText(w io.Writer) error {
+	_, err := fmt.Fprintf(w, "Processed %d templates (%d failed) in %s with %d workers\n"+
+		"Render time: p50 %s, p95 %s, max %s\n"+
+		"Written: %d bytes\n"+
+		"Template cache: %d hits, %d misses (%.0f%% hit ratio)\n"+
+		"Memory: %d bytes peak, %d bytes at end\n",
+		s.TemplateCount, s.ErrorCount, s.ProcessingTime.Round(time.Millisecond), s.WorkerCount,
+		s.Timings.P50.Round(time.Microsecond), s.Timings.P95.Round(time.Microsecond), s.Timings.Max.Round(time.Microsecond),
+		s.BytesWritten,
+		s.CacheHits, s.CacheMisses, s.CacheHitRatio()*100,
+		s.PeakMemory, s.MemoryUsage)
+	if err != nil {
+		return fmt.Errorf("error writing stats: %w", err)
+	}
+	return nil
+}
+
+// summarize returns the p50, p95 and max of durations, by nearest rank
+func summarize(durations []time.Duration) TimingSummary {
+	if len(durations) == 0 {
+		return TimingSummary{}
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	rank := func(p int) time.Duration {
+		// Smallest duration that at least p percent of the durations do not exceed
+		return sorted[(p*len(sorted)+99)/100-1]
+	}
+	return TimingSummary{P50: rank(50), P95: rank(95), Max: sorted[len(sorted)-1]}
+}
+
+// runRecorder collects the stats of one run. record is only called from the
+// goroutine collecting results, memory is sampled concurrently.
+type runRecorder struct {
+	stats     ProcessingStats
+	durations []time.Duration
+	cache     engine.CacheStats
+
+	mu   sync.Mutex
+	peak uint64
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// memorySampleInterval is how often memory is sampled while a run is in progress
+var memorySampleInterval = 50 * time.Millisecond
+
+// startRun starts recording a run and sampling its memory use
+func startRun(workers int) *runRecorder {
+	r := &runRecorder{
+		stats: ProcessingStats{StartTime: time.Now(), WorkerCount: workers},
+		cache: engine.TemplateCacheStats(),
+		done:  make(chan struct{}),
+	}
+	r.sample()
+
+	ctx, stop := context.WithCancel(context.Background())
+	r.stop = stop
+	go func() {
+		defer close(r.done)
+		ticker := time.NewTicker(memorySampleInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				r.sample()
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return r
+}
+
+// sample records the memory in use if it is the most seen so far, and returns it
+func (r *runRecorder) sample() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if m.Alloc > r.peak {
+		r.peak = m.Alloc
+	}
+	return m.Alloc
+}
+
+// record adds a job that was started to the run
+func (r *runRecorder) record(timing TemplateTiming) {
+	if timing.Failed {
+		r.stats.ErrorCount++
+	} else {
+		r.stats.TemplateCount++
+		r.stats.BytesWritten += timing.Bytes
+	}
+	r.durations = append(r.durations, timing.Duration)
+	r.stats.Templates = append(r.stats.Templates, timing)
+}
+
+// finish stops sampling and returns the stats of the run
+func (r *runRecorder) finish() ProcessingStats {
+	r.stop()
+	<-r.done
+
+	stats := r.stats
+	stats.EndTime = time.Now()
+	stats.ProcessingTime = stats.EndTime.Sub(stats.StartTime)
+	stats.MemoryUsage = r.sample()
+	stats.PeakMemory = r.peak
+	stats.Timings = summarize(r.durations)
+	// List jobs in job order, whatever order they completed in
+	sort.Slice(stats.Templates, func(i, j int) bool { return stats.Templates[i].index < stats.Templates[j].index })
+
+	cache := engine.TemplateCacheStats()
+	stats.CacheHits = int(cache.Hits - r.cache.Hits)
+	stats.CacheMisses = int(cache.Misses - r.cache.Misses)
+
+	return stats
+}
+
+// copyStats returns a copy of stats that shares nothing with it, or empty stats if it is nil
+func copyStats(stats *ProcessingStats) *ProcessingStats {
+	if stats == nil {
+		return &ProcessingStats{}
+	}
+	c := *stats
+	c.Templates = append([]TemplateTiming(nil), stats.Templates...)
+	return &c
+}
+
+// MonitorResources calls callback with the memory in use every interval until ctx is
+// done. The stats passed cover the monitoring so far: MemoryUsage is the latest sample,
+// PeakMemory the most seen and ProcessingTime the time since monitoring started.
+func MonitorResources(ctx context.Context, interval time.Duration, callback func(*ProcessingStats)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	var peak uint64
+	for {
+		select {
+		case now := <-ticker.C:
+			var m runtime.MemStats
+			runtime.ReadMemStats(&m)
+			if m.Alloc > peak {
+				peak = m.Alloc
+			}
+
+			callback(&ProcessingStats{
+				StartTime:      start,
+				EndTime:        now,
+				ProcessingTime: now.Sub(start),
+				MemoryUsage:    m.Alloc,
+				PeakMemory:     peak,
+			})
+		case <-ctx.Done():
+			return
+		}
+	}
+}
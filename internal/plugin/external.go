@@ -0,0 +1,413 @@
+package plugin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/singoesdeep/templater/internal/engine"
+	"github.com/singoesdeep/templater/internal/ui"
+)
+
+const (
+	// defaultCallTimeout bounds the handshake and each call to an external plugin
+	defaultCallTimeout = 10 * time.Second
+	// maxRestarts is how often a crashed or hung plugin is restarted before it is disabled
+	maxRestarts = 3
+	// maxMessageSize bounds a single response line
+	maxMessageSize = 64 << 20
+)
+
+// ExternalOptions controls how external plugins are run
+type ExternalOptions struct {
+	// Timeout bounds the handshake and each call; a plugin exceeding it is killed and
+	// restarted on the next call. 0 selects 10s.
+	Timeout time.Duration
+}
+
+// External is a plugin running as a separate executable, speaking JSON-RPC 2.0 over its
+// standard input and output with one message per line. Its standard error is printed
+// behind a prefix. A plugin that crashes or times out fails the call in progress and
+// is restarted on the next one, up to three times.
+type External struct {
+	path    string
+	timeout time.Duration
+	info    initializeResult
+
+	mu       sync.Mutex
+	proc     *process
+	restarts int
+	closed   bool
+}
+
+// process is a running external plugin
+type process struct {
+	cmd       *exec.Cmd
+	stdin     io.WriteCloser
+	stderr    *ui.PrefixWriter
+	responses chan rpcResponse
+	// done is closed when the plugin's output ends, after err is set
+	done chan struct{}
+	err  error
+	// stopped is closed when the plugin is stopped, releasing the reader
+	stopped chan struct{}
+	nextID  int64
+}
+
+// StartExternal starts the plugin executable at path and performs the handshake
+func StartExternal(path string, opts ExternalOptions) (*External, error) {
+	e := &External{path: path, timeout: opts.Timeout}
+	if e.timeout <= 0 {
+		e.timeout = defaultCallTimeout
+	}
+
+	if err := e.start(); err != nil {
+		return nil, err
+	}
+	if !namePattern.MatchString(e.info.Name) {
+		e.Close()
+		return nil, fmt.Errorf("plugin %s: invalid name %q", path, e.info.Name)
+	}
+	for _, name := range e.info.Capabilities.Funcs {
+		if !namePattern.MatchString(name) {
+			e.Close()
+			return nil, fmt.Errorf("plugin %s: invalid template function name %q", e.info.Name, name)
+		}
+	}
+	return e, nil
+}
+
+// start runs the plugin and performs the handshake; callers other than
+// StartExternal must hold e.mu
+func (e *External) start() error {
+	cmd := exec.Command(e.path)
+	cmd.Dir = filepath.Dir(e.path)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", e.path, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", e.path, err)
+	}
+	label := strings.TrimSuffix(filepath.Base(e.path), filepath.Ext(e.path))
+	stderr := ui.NewPrefixWriter(fmt.Sprintf("[plugin %s]", label), ui.WarnColor)
+	cmd.Stderr = stderr
+	// Don't wait for descendants of the plugin holding on to its standard error
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting plugin %s: %w", e.path, err)
+	}
+	p := &process{
+		cmd:       cmd,
+		stdin:     stdin,
+		stderr:    stderr,
+		responses: make(chan rpcResponse),
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go p.read(stdout)
+	e.proc = p
+
+	var info initializeResult
+	params := initializeParams{ProtocolVersion: ProtocolVersion, TemplaterVersion: engine.Version}
+	if err := e.roundTrip(methodInitialize, params, &info); err != nil {
+		e.stop()
+		return fmt.Errorf("plugin %s: handshake failed: %w", e.path, err)
+	}
+	if info.ProtocolVersion != ProtocolVersion {
+		e.stop()
+		return fmt.Errorf("plugin %s speaks protocol version %d, templater supports %d", e.path, info.ProtocolVersion, ProtocolVersion)
+	}
+	if e.info.Name != "" && info.Name != e.info.Name {
+		e.stop()
+		return fmt.Errorf("plugin %s changed its name from %s to %s on restart", e.path, e.info.Name, info.Name)
+	}
+	e.info = info
+	return nil
+}
+
+// read delivers the responses of the plugin until its output ends or is not valid JSON-RPC
+func (p *process) read(stdout io.Reader) {
+	defer close(p.done)
+	reader := bufio.NewReaderSize(stdout, 64<<10)
+	for {
+		line, err := readLine(reader)
+		if err != nil {
+			p.err = err
+			if errors.Is(err, io.EOF) {
+				p.err = errors.New("plugin exited")
+			}
+			break
+		}
+		if len(strings.TrimSpace(string(line))) == 0 {
+			continue
+		}
+
+		var response rpcResponse
+		if err := json.Unmarshal(line, &response); err != nil {
+			p.err = fmt.Errorf("invalid message from plugin: %w", err)
+			break
+		}
+		select {
+		case p.responses <- response:
+		case <-p.stopped:
+			return
+		}
+	}
+}
+
+// readLine reads a line of at most maxMessageSize bytes
+func readLine(reader *bufio.Reader) ([]byte, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, chunk...)
+		if len(line) > maxMessageSize {
+			return nil, fmt.Errorf("message from plugin exceeds %d bytes", maxMessageSize)
+		}
+		if !isPrefix {
+			return line, nil
+		}
+	}
+}
+
+// call invokes method, restarting the plugin if it is not running
+func (e *External) call(method string, params, result any) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return fmt.Errorf("plugin %s is closed", e.info.Name)
+	}
+	if e.proc == nil {
+		if e.restarts >= maxRestarts {
+			return fmt.Errorf("plugin %s is disabled after failing %d times", e.info.Name, e.restarts)
+		}
+		e.restarts++
+		if err := e.start(); err != nil {
+			return err
+		}
+	}
+
+	if err := e.roundTrip(method, params, result); err != nil {
+		return fmt.Errorf("plugin %s: %s: %w", e.info.Name, method, err)
+	}
+	return nil
+}
+
+// roundTrip sends a request to the running plugin and decodes its response into result.
+// A plugin that exits, times out or answers out of turn is stopped. Callers must hold
+// e.mu, except in StartExternal.
+func (e *External) roundTrip(method string, params, result any) error {
+	p := e.proc
+	p.nextID++
+	request, err := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: p.nextID, Method: method, Params: params})
+	if err != nil {
+		return fmt.Errorf("error encoding request: %w", err)
+	}
+	if _, err := p.stdin.Write(append(request, '\n')); err != nil {
+		e.stop()
+		return fmt.Errorf("error writing to plugin: %w", err)
+	}
+
+	timer := time.NewTimer(e.timeout)
+	defer timer.Stop()
+	select {
+	case response := <-p.responses:
+		if response.ID != p.nextID {
+			e.stop()
+			return fmt.Errorf("response id %d does not match request id %d", response.ID, p.nextID)
+		}
+		if response.Error != nil {
+			return response.Error
+		}
+		if result == nil {
+			return nil
+		}
+		if err := json.Unmarshal(response.Result, result); err != nil {
+			return fmt.Errorf("invalid result: %w", err)
+		}
+		return nil
+	case <-p.done:
+		err := p.err
+		e.stop()
+		return err
+	case <-timer.C:
+		e.stop()
+		return fmt.Errorf("timed out after %s", e.timeout)
+	}
+}
+
+// stop kills the running plugin; callers must hold e.mu
+func (e *External) stop() {
+	p := e.proc
+	if p == nil {
+		return
+	}
+	e.proc = nil
+	close(p.stopped)
+	p.stdin.Close()
+	p.cmd.Process.Kill()
+	p.cmd.Wait()
+	p.stderr.Flush()
+}
+
+// Close asks the plugin to shut down, killing it if it does not exit in time
+func (e *External) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.closed = true
+	p := e.proc
+	if p == nil {
+		return nil
+	}
+	e.proc = nil
+	close(p.stopped)
+
+	exited := make(chan error, 1)
+	go func() {
+		// The plugin may exit without answering, so the response is not awaited
+		request, _ := json.Marshal(rpcRequest{JSONRPC: "2.0", ID: p.nextID + 1, Method: methodShutdown})
+		p.stdin.Write(append(request, '\n'))
+		p.stdin.Close()
+		exited <- p.cmd.Wait()
+	}()
+
+	var err error
+	select {
+	case err = <-exited:
+	case <-time.After(e.timeout):
+		p.cmd.Process.Kill()
+		err = <-exited
+	}
+	p.stderr.Flush()
+	if err != nil {
+		return fmt.Errorf("plugin %s: %w", e.info.Name, err)
+	}
+	return nil
+}
+
+// Path returns the plugin's executable
+func (e *External) Path() string {
+	return e.path
+}
+
+// Name returns the name the plugin reported in its handshake
+func (e *External) Name() string {
+	return e.info.Name
+}
+
+// Version returns the version the plugin reported in its handshake
+func (e *External) Version() string {
+	return e.info.Version
+}
+
+// Description returns the description the plugin reported in its handshake
+func (e *External) Description() string {
+	return e.info.Description
+}
+
+// Execute sends input to the plugin's execute method
+func (e *External) Execute(input string) (string, error) {
+	var result executeResult
+	if err := e.call(methodExecute, executeParams{Input: input}, &result); err != nil {
+		return "", err
+	}
+	return result.Output, nil
+}
+
+// Funcs returns the template functions the plugin reported, each calling its func
+// method with the arguments encoded as JSON
+func (e *External) Funcs() template.FuncMap {
+	funcs := make(template.FuncMap, len(e.info.Capabilities.Funcs))
+	for _, name := range e.info.Capabilities.Funcs {
+		funcs[name] = func(args ...any) (any, error) {
+			if args == nil {
+				args = []any{}
+			}
+			var result funcResult
+			if err := e.call(methodFunc, funcParams{Name: name, Args: args}, &result); err != nil {
+				return nil, err
+			}
+			return result.Result, nil
+		}
+	}
+	return funcs
+}
+
+// DataExtensions returns the data file extensions the plugin reported
+func (e *External) DataExtensions() []string {
+	return e.info.Capabilities.DataExtensions
+}
+
+// LoadData sends a data file to the plugin's load_data method
+func (e *External) LoadData(path string, content []byte) (map[string]string, error) {
+	var result loadDataResult
+	if err := e.call(methodLoadData, loadDataParams{Path: path, Content: string(content)}, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// PostProcess sends an output to the plugin's post_process method, or to execute when
+// the plugin did not report handling post_process
+func (e *External) PostProcess(path, content string) (string, error) {
+	if !e.info.Capabilities.PostProcess {
+		return e.Execute(content)
+	}
+	var result postProcessResult
+	if err := e.call(methodPostProcess, postProcessParams{Path: path, Content: content}, &result); err != nil {
+		return "", err
+	}
+	return result.Content, nil
+}
+
+// Discover returns the plugin executables in dir, sorted by name. Hidden files,
+// directories and files that are not executable are skipped. A missing dir has no plugins.
+func Discover(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading plugin directory: %w", err)
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if runtime.GOOS == "windows" {
+			if !strings.EqualFold(filepath.Ext(entry.Name()), ".exe") {
+				continue
+			}
+		} else if info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+		paths = append(paths, filepath.Join(dir, entry.Name()))
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
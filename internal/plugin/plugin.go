@@ -0,0 +1,70 @@
+package plugin
+
+import (
+	"text/template"
+)
+
+// Plugin extends templater. Execute transforms text: it is available to templates as a
+// function named after the plugin, and to targets as a post-processor of the same name.
+type Plugin interface {
+	Name() string
+	Description() string
+	Execute(input string) (string, error)
+}
+
+// Versioned is implemented by plugins that report their version
+type Versioned interface {
+	Version() string
+}
+
+// FuncProvider is implemented by plugins contributing template functions besides Execute.
+// Targets enable them, together with Execute, by listing the plugin in funcs.
+type FuncProvider interface {
+	Funcs() template.FuncMap
+}
+
+// DataLoader is implemented by plugins that read data files of other formats than JSON and YAML
+type DataLoader interface {
+	// DataExtensions returns the file extensions loaded by the plugin, such as ".toml"
+	DataExtensions() []string
+	// LoadData parses the content of the data file read from path
+	LoadData(path string, content []byte) (map[string]string, error)
+}
+
+// PostProcessor is implemented by plugins whose post-processor needs the output path.
+// It replaces Execute as the plugin's post-processor.
+type PostProcessor interface {
+	PostProcess(path, content string) (string, error)
+}
+
+// Capabilities of plugins, as listed by Capabilities
+const (
+	CapabilityFuncs         = "funcs"
+	CapabilityDataLoader    = "data"
+	CapabilityPostProcessor = "post-process"
+)
+
+// Capabilities returns what p contributes: template functions and a post-processor,
+// which every plugin provides through Execute, and data loading for plugins reporting
+// data file extensions
+func Capabilities(p Plugin) []string {
+	capabilities := []string{CapabilityFuncs, CapabilityPostProcessor}
+	if loader, ok := p.(DataLoader); ok && len(loader.DataExtensions()) > 0 {
+		capabilities = append(capabilities, CapabilityDataLoader)
+	}
+	return capabilities
+}
+
+// Info describes a registered plugin
+type Info struct {
+	Name        string `json:"name"`
+	Version     string `json:"version,omitempty"`
+	Description string `json:"description"`
+	// Path is the executable of an external plugin
+	Path string `json:"path,omitempty"`
+	// Funcs are the names of the template functions the plugin contributes
+	Funcs []string `json:"funcs"`
+	// DataExtensions are the data file extensions the plugin loads
+	DataExtensions []string `json:"data_extensions,omitempty"`
+	Capabilities   []string `json:"capabilities"`
+}
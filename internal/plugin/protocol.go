@@ -0,0 +1,100 @@
+package plugin
+
+import "encoding/json"
+
+// ProtocolVersion is the version of the JSON-RPC protocol spoken with external plugins.
+// Plugins reporting another version in their handshake are rejected.
+const ProtocolVersion = 1
+
+// Methods of the external plugin protocol
+const (
+	methodInitialize  = "initialize"
+	methodExecute     = "execute"
+	methodFunc        = "func"
+	methodLoadData    = "load_data"
+	methodPostProcess = "post_process"
+	methodShutdown    = "shutdown"
+)
+
+// rpcRequest is a JSON-RPC 2.0 request, written to the plugin as a single line
+type rpcRequest struct {
+	JSONRPC string `json:"jsonrpc"`
+	ID      int64  `json:"id"`
+	Method  string `json:"method"`
+	Params  any    `json:"params,omitempty"`
+}
+
+// rpcResponse is a JSON-RPC 2.0 response, read from the plugin as a single line
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      int64           `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+}
+
+// RPCError is an error returned by an external plugin
+type RPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RPCError) Error() string {
+	return e.Message
+}
+
+// initializeParams are sent in the handshake
+type initializeParams struct {
+	ProtocolVersion  int    `json:"protocol_version"`
+	TemplaterVersion string `json:"templater_version"`
+}
+
+// initializeResult describes a plugin in the handshake
+type initializeResult struct {
+	ProtocolVersion int    `json:"protocol_version"`
+	Name            string `json:"name"`
+	Version         string `json:"version"`
+	Description     string `json:"description"`
+	Capabilities    struct {
+		// Funcs are the names of the template functions the plugin provides
+		Funcs []string `json:"funcs"`
+		// DataExtensions are the data file extensions the plugin loads
+		DataExtensions []string `json:"data_extensions"`
+		// PostProcess is set when the plugin handles post_process instead of execute
+		PostProcess bool `json:"post_process"`
+	} `json:"capabilities"`
+}
+
+type executeParams struct {
+	Input string `json:"input"`
+}
+
+type executeResult struct {
+	Output string `json:"output"`
+}
+
+type funcParams struct {
+	Name string `json:"name"`
+	Args []any  `json:"args"`
+}
+
+type funcResult struct {
+	Result any `json:"result"`
+}
+
+type loadDataParams struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+type loadDataResult struct {
+	Data map[string]string `json:"data"`
+}
+
+type postProcessParams struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+type postProcessResult struct {
+	Content string `json:"content"`
+}
@@ -0,0 +1,255 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/singoesdeep/templater/internal/engine"
+	"github.com/singoesdeep/templater/internal/ui"
+)
+
+// namePattern restricts plugin names to identifiers usable as template functions
+var namePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// Registry holds plugins and makes their capabilities available to templates and targets
+type Registry struct {
+	sync.RWMutex
+	plugins map[string]Plugin
+	// loaded are the executables of the external plugins loaded by LoadDir
+	loaded map[string]bool
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{plugins: make(map[string]Plugin), loaded: make(map[string]bool)}
+}
+
+// Default is the registry of the templater command, holding the built-in plugins
+var Default = NewRegistry()
+
+func init() {
+	if err := Default.Register(NewUppercasePlugin()); err != nil {
+		panic(err)
+	}
+}
+
+// Register adds a plugin to the default registry
+func Register(p Plugin) error {
+	return Default.Register(p)
+}
+
+// Register adds a plugin and registers its template function set and post-processor
+// under its name with the engine, along with its data loaders. Names must be unique
+// and must not clash with built-in function sets or post-processors, and neither its
+// template functions, which must not shadow the built-in ones such as upper, nor its data extensions may be claimed by another set or plugin.
+func (r *Registry) Register(p Plugin) error {
+	name := p.Name()
+	if !namePattern.MatchString(name) {
+		return fmt.Errorf("invalid plugin name %q: must be a letter followed by letters, digits or underscores", name)
+	}
+
+	r.Lock()
+	defer r.Unlock()
+	if _, exists := r.plugins[name]; exists {
+		return fmt.Errorf("plugin %s is already registered", name)
+	}
+	if slices.Contains(engine.FuncSetNames(), name) {
+		return fmt.Errorf("plugin %s clashes with the template function set of the same name", name)
+	}
+	if slices.Contains(engine.PostProcessorNames(), name) {
+		return fmt.Errorf("plugin %s clashes with the post-processor of the same name", name)
+	}
+
+	funcs := pluginFuncs(p)
+	for fn := range funcs {
+		if _, exists := engine.TemplateFuncs[fn]; exists {
+			return fmt.Errorf("plugin %s: template function %s clashes with the built-in template function of the same name", name, fn)
+		}
+		if set, exists := engine.FuncSetDefining(fn); exists {
+			return fmt.Errorf("plugin %s: template function %s clashes with the function of the same name in set %s", name, fn, set)
+		}
+	}
+
+	var loader DataLoader
+	if l, ok := p.(DataLoader); ok {
+		loader = l
+		registered := engine.DataLoaderExtensions()
+		for _, ext := range l.DataExtensions() {
+			if !strings.HasPrefix(ext, ".") {
+				return fmt.Errorf("plugin %s: data extension %q must start with a dot", name, ext)
+			}
+			if slices.Contains(registered, strings.ToLower(ext)) {
+				return fmt.Errorf("plugin %s: data extension %s already has a data loader", name, ext)
+			}
+		}
+	}
+
+	engine.RegisterFuncs(name, funcs)
+	if processor, ok := p.(PostProcessor); ok {
+		engine.RegisterPostProcessor(name, processor.PostProcess)
+	} else {
+		engine.RegisterPostProcessor(name, func(_ string, content string) (string, error) {
+			return p.Execute(content)
+		})
+	}
+	if loader != nil {
+		for _, ext := range loader.DataExtensions() {
+			engine.RegisterDataLoader(ext, loader.LoadData)
+		}
+	}
+
+	r.plugins[name] = p
+	return nil
+}
+
+// LoadDir starts the plugin executables in dir that were not loaded before and registers
+// them. A plugin that fails to start or register does not keep the others from loading;
+// the failures are returned together.
+func (r *Registry) LoadDir(dir string, opts ExternalOptions) error {
+	paths, err := Discover(dir)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, path := range paths {
+		r.RLock()
+		loaded := r.loaded[path]
+		r.RUnlock()
+		if loaded {
+			continue
+		}
+
+		external, err := StartExternal(path, opts)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := r.Register(external); err != nil {
+			external.Close()
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			continue
+		}
+		r.Lock()
+		r.loaded[path] = true
+		r.Unlock()
+	}
+	return errors.Join(errs...)
+}
+
+// Close shuts down the external plugins of the registry. They stay registered, but
+// fail when called.
+func (r *Registry) Close() error {
+	r.RLock()
+	defer r.RUnlock()
+
+	var errs []error
+	for _, p := range r.plugins {
+		if closer, ok := p.(io.Closer); ok {
+			if err := closer.Close(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pluginFuncs returns the template functions of a plugin: Execute under the plugin's
+// name and those of a FuncProvider
+func pluginFuncs(p Plugin) template.FuncMap {
+	funcs := template.FuncMap{}
+	if provider, ok := p.(FuncProvider); ok {
+		for name, fn := range provider.Funcs() {
+			funcs[name] = fn
+		}
+	}
+	funcs[p.Name()] = p.Execute
+	return funcs
+}
+
+// Get returns the plugin registered under name
+func (r *Registry) Get(name string) (Plugin, bool) {
+	r.RLock()
+	defer r.RUnlock()
+	p, exists := r.plugins[name]
+	return p, exists
+}
+
+// List describes the registered plugins, sorted by name
+func (r *Registry) List() []Info {
+	r.RLock()
+	defer r.RUnlock()
+
+	infos := make([]Info, 0, len(r.plugins))
+	for _, p := range r.plugins {
+		infos = append(infos, describe(p))
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// describe returns the Info of a plugin
+func describe(p Plugin) Info {
+	info := Info{Name: p.Name(), Description: p.Description(), Capabilities: Capabilities(p)}
+	if external, ok := p.(*External); ok {
+		info.Path = external.Path()
+	}
+	if v, ok := p.(Versioned); ok {
+		info.Version = v.Version()
+	}
+	for name := range pluginFuncs(p) {
+		info.Funcs = append(info.Funcs, name)
+	}
+	sort.Strings(info.Funcs)
+	if loader, ok := p.(DataLoader); ok {
+		info.DataExtensions = loader.DataExtensions()
+	}
+	return info
+}
+
+// PrintList prints the registered plugins with their capabilities as a table
+func (r *Registry) PrintList() {
+	infos := r.List()
+	if len(infos) == 0 {
+		ui.PrintInfo("No plugins registered")
+		return
+	}
+
+	rows := make([][]string, len(infos))
+	for i, info := range infos {
+		rows[i] = []string{info.Name, info.Version, strings.Join(info.Capabilities, ", "), info.Description}
+	}
+	ui.PrintTable([]string{"NAME", "VERSION", "CAPABILITIES", "DESCRIPTION"}, rows)
+}
+
+// PrintInfo prints the details of the named plugin
+func (r *Registry) PrintInfo(name string) error {
+	p, exists := r.Get(name)
+	if !exists {
+		return fmt.Errorf("plugin not found: %s", name)
+	}
+
+	info := describe(p)
+	fmt.Printf("Name:         %s\n", info.Name)
+	if info.Version != "" {
+		fmt.Printf("Version:      %s\n", info.Version)
+	}
+	fmt.Printf("Description:  %s\n", info.Description)
+	fmt.Printf("Capabilities: %s\n", strings.Join(info.Capabilities, ", "))
+	fmt.Printf("Functions:    %s (enable with funcs: [%s])\n", strings.Join(info.Funcs, ", "), info.Name)
+	fmt.Printf("Post-process: post_process: [%s]\n", info.Name)
+	if len(info.DataExtensions) > 0 {
+		fmt.Printf("Data files:   %s\n", strings.Join(info.DataExtensions, ", "))
+	}
+	if info.Path != "" {
+		fmt.Printf("Executable:   %s\n", info.Path)
+	}
+	return nil
+}
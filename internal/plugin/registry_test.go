@@ -0,0 +1,40 @@
+package plugin
+
+import (
+	"strings"
+	"testing"
+	"text/template"
+)
+
+// funcsPlugin is a plugin contributing the given template functions
+type funcsPlugin struct {
+	name  string
+	funcs template.FuncMap
+}
+
+func (p *funcsPlugin) Name() string                         { return p.name }
+func (p *funcsPlugin) Description() string                  { return "test plugin" }
+func (p *funcsPlugin) Execute(input string) (string, error) { return input, nil }
+func (p *funcsPlugin) Funcs() template.FuncMap              { return p.funcs }
+
+func TestRegisterRejectsBuiltinFuncs(t *testing.T) {
+	tests := []struct {
+		name string
+		p    Plugin
+	}{
+		{"function upper", &funcsPlugin{name: "shouting", funcs: template.FuncMap{"upper": strings.ToUpper}}},
+		{"plugin named upper", &funcsPlugin{name: "upper"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewRegistry()
+			err := r.Register(tt.p)
+			if err == nil || !strings.Contains(err.Error(), "built-in template function") {
+				t.Fatalf("Register returned %v, want a clash with the built-in upper", err)
+			}
+			if _, exists := r.Get(tt.p.Name()); exists {
+				t.Errorf("rejected plugin %s is registered", tt.p.Name())
+			}
+		})
+	}
+}
@@ -0,0 +1,31 @@
+package plugin
+
+import "strings"
+
+// UppercasePlugin converts text to uppercase
+type UppercasePlugin struct{}
+
+// NewUppercasePlugin creates the uppercase plugin
+func NewUppercasePlugin() *UppercasePlugin {
+	return &UppercasePlugin{}
+}
+
+// Name returns the plugin name
+func (p *UppercasePlugin) Name() string {
+	return "uppercase"
+}
+
+// Version returns the plugin version
+func (p *UppercasePlugin) Version() string {
+	return "1.0.0"
+}
+
+// Description returns what the plugin does
+func (p *UppercasePlugin) Description() string {
+	return "Converts text to uppercase"
+}
+
+// Execute converts input to uppercase
+func (p *UppercasePlugin) Execute(input string) (string, error) {
+	return strings.ToUpper(input), nil
+}
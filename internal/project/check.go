@@ -0,0 +1,110 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/singoesdeep/templater/internal/config"
+	"github.com/singoesdeep/templater/internal/engine"
+	"github.com/singoesdeep/templater/internal/ui"
+)
+
+// DriftKind describes how a generated file differs from what its template renders
+type DriftKind string
+
+const (
+	// DriftMissing is an output that does not exist
+	DriftMissing DriftKind = "missing"
+	// DriftStale is an output not regenerated since its inputs changed
+	DriftStale DriftKind = "stale"
+	// DriftModified is an output edited since it was generated, according to the manifest
+	DriftModified DriftKind = "modified"
+)
+
+// Drift is a generated file that does not match what its template renders
+type Drift struct {
+	Target   string
+	Template string
+	Path     string
+	Kind     DriftKind
+	// Diff is the unified diff from the file on disk to the rendered content
+	Diff string
+}
+
+// Check renders every job of the configured targets in memory and compares the
+// results with the files on disk, without writing anything or running hooks. It
+// returns the outputs that differ; templates that fail to render are returned
+// together as the error.
+func Check(cfg *config.Config) ([]Drift, error) {
+	jobs, err := Jobs(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	baseDir := cfg.BaseDir()
+	manifest := LoadManifest(baseDir)
+
+	var drifts []Drift
+	var errs []error
+	for _, job := range jobs {
+		outputs, err := job.Render()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		var recorded map[string]string
+		if entry := manifest.lookup(job.Target, relPath(baseDir, job.Template)); entry != nil {
+			recorded = entry.Outputs
+		}
+
+		for _, output := range outputs {
+			drift := Drift{Target: job.Target, Template: job.Template, Path: output.Path}
+			name := relPath(baseDir, output.Path)
+			// Merging targets keep hand edits, so compare with what generate would write
+			if job.Merge {
+				if output.Content, _, err = engine.MergedContent(output.Path, output.Content); err != nil {
+					errs = append(errs, fmt.Errorf("target %s: %w", job.Target, err))
+					continue
+				}
+			}
+
+			current, err := os.ReadFile(output.Path)
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				drift.Kind = DriftMissing
+				drift.Diff = outputDiff("/dev/null", name, "", output.Content)
+				drifts = append(drifts, drift)
+				continue
+			case err != nil:
+				errs = append(errs, fmt.Errorf("target %s: error reading %s: %w", job.Target, output.Path, err))
+				continue
+			case string(current) == output.Content:
+				continue
+			}
+
+			// The manifest, or else the provenance in the file's header, tells hand edits
+			// apart from outputs that are merely out of date
+			drift.Kind = DriftStale
+			if hash, ok := recorded[name]; ok {
+				if onDisk, err := hashFile(output.Path); err == nil && onDisk != hash {
+					drift.Kind = DriftModified
+				}
+			} else if provenance, body := engine.ParseHeader(string(current)); provenance != nil && !provenance.Matches(body) {
+				drift.Kind = DriftModified
+			}
+			drift.Diff = outputDiff(name, name+" (generated)", string(current), output.Content)
+			drifts = append(drifts, drift)
+		}
+	}
+
+	return drifts, errors.Join(errs...)
+}
+
+// outputDiff returns the unified diff of a generated file. Patience diffs keep changes
+// to generated code grouped by declaration.
+func outputDiff(oldName, newName, old, new string) string {
+	return ui.FormatDiff(old, new, ui.DiffOptions{OldName: oldName, NewName: newName, Patience: true})
+}
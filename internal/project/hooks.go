@@ -0,0 +1,239 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/singoesdeep/templater/internal/config"
+	"github.com/singoesdeep/templater/internal/engine"
+	"github.com/singoesdeep/templater/internal/ui"
+)
+
+// HookEvent names the point of a target's run a hook is attached to
+type HookEvent string
+
+const (
+	// HookPreRender runs before the target's jobs render
+	HookPreRender HookEvent = "pre_render"
+	// HookPostWrite runs after all of the target's jobs wrote their outputs
+	HookPostWrite HookEvent = "post_write"
+	// HookOnError runs when a job of the target or one of its other hooks failed
+	HookOnError HookEvent = "on_error"
+)
+
+// Environment variables passed to hooks
+const (
+	// EnvHookTarget holds the name of the target
+	EnvHookTarget = "TEMPLATER_TARGET"
+	// EnvHookEvent holds the hook event, e.g. post_write
+	EnvHookEvent = "TEMPLATER_HOOK"
+	// EnvHookTemplate lists the target's template paths, one per line
+	EnvHookTemplate = "TEMPLATER_TEMPLATE"
+	// EnvHookChangedFiles lists the changed input files, one per line
+	EnvHookChangedFiles = "TEMPLATER_CHANGED_FILES"
+	// EnvHookOutputFiles lists the written output files, one per line
+	EnvHookOutputFiles = "TEMPLATER_OUTPUT_FILES"
+	// EnvHookError holds the error message for on_error hooks
+	EnvHookError = "TEMPLATER_ERROR"
+)
+
+// HookError reports a hook command that failed
+type HookError struct {
+	Target  string
+	Event   HookEvent
+	Command string
+	Err     error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("target %s: %s hook %q failed: %v", e.Target, e.Event, e.Command, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// hookRun describes the run hooks are executed for
+type hookRun struct {
+	changed []string
+	written []string
+	err     error
+}
+
+// targetHooks are the hooks of a target, run once around all of its jobs
+type targetHooks struct {
+	target    string
+	templates []string
+	hooks     config.Hooks
+	dir       string
+}
+
+// hooksOf returns the hooks of the target jobs belong to
+func hooksOf(jobs []Job) targetHooks {
+	h := targetHooks{target: jobs[0].Target, hooks: jobs[0].Hooks, dir: jobs[0].Dir}
+	for _, job := range jobs {
+		h.templates = append(h.templates, job.Template)
+	}
+	return h
+}
+
+// commands returns the target's hook commands for event
+func (h targetHooks) commands(event HookEvent) []string {
+	switch event {
+	case HookPreRender:
+		return h.hooks.PreRender
+	case HookPostWrite:
+		return h.hooks.PostWrite
+	case HookOnError:
+		return h.hooks.OnError
+	}
+	return nil
+}
+
+// run runs the target's commands for event in order, stopping at the first failure.
+// Their output is streamed through the ui package behind a "[target event]" prefix.
+func (h targetHooks) run(event HookEvent, run hookRun) error {
+	commands := h.commands(event)
+	if len(commands) == 0 {
+		return nil
+	}
+
+	env := append(os.Environ(),
+		EnvHookTarget+"="+h.target,
+		EnvHookEvent+"="+string(event),
+		EnvHookTemplate+"="+strings.Join(h.templates, "\n"),
+		EnvHookChangedFiles+"="+strings.Join(run.changed, "\n"),
+		EnvHookOutputFiles+"="+strings.Join(run.written, "\n"),
+	)
+	if run.err != nil {
+		env = append(env, EnvHookError+"="+run.err.Error())
+	}
+
+	for _, command := range commands {
+		stdout := ui.NewPrefixWriter(fmt.Sprintf("[%s %s]", h.target, event), ui.InfoColor)
+		stderr := ui.NewPrefixWriter(fmt.Sprintf("[%s %s]", h.target, event), ui.WarnColor)
+
+		cmd := shellCommand(command)
+		cmd.Dir = h.dir
+		cmd.Env = env
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		err := cmd.Run()
+		stdout.Flush()
+		stderr.Flush()
+
+		if err != nil {
+			return &HookError{Target: h.target, Event: event, Command: command, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// failed runs the target's on_error hooks for err and returns the hook failure, if any
+func (h targetHooks) failed(err error, run hookRun) error {
+	run.err = err
+	return h.run(HookOnError, run)
+}
+
+// JobResult is the outcome of a job run as part of its target
+type JobResult struct {
+	Job Job
+	// Outputs are the rendered outputs, nil when the job failed
+	Outputs []engine.RenderedOutput
+	// Written are the paths of the outputs written
+	Written []string
+	// Err is the job's rendering or writing error
+	Err error
+}
+
+// TargetRun is the outcome of running the jobs of a target with its hooks
+type TargetRun struct {
+	// Jobs are the results of the jobs, nil when a pre_render hook failed
+	Jobs []JobResult
+	// HookErr reports failing hooks: pre_render, which skips every job, post_write,
+	// and on_error
+	HookErr error
+}
+
+// Err returns the errors of the jobs and hooks together
+func (r *TargetRun) Err() error {
+	var errs []error
+	for _, result := range r.Jobs {
+		errs = append(errs, result.Err)
+	}
+	return errors.Join(append(errs, r.HookErr)...)
+}
+
+// RunTarget runs jobs, which must all belong to the same target, and the target's hooks
+// around them: pre_render once before the first job, post_write once after every job
+// wrote its outputs, and on_error once when a job or hook failed. changed lists the
+// changed files passed to the hooks; the hooks also get the outputs written by all jobs.
+func RunTarget(jobs []Job, changed []string) *TargetRun {
+	return runTarget(jobs, changed, true)
+}
+
+// runTarget is RunTarget, rewriting outputs whose file already holds their content
+// only if writeUnchanged is set
+func runTarget(jobs []Job, changed []string, writeUnchanged bool) *TargetRun {
+	result := &TargetRun{}
+	if len(jobs) == 0 {
+		return result
+	}
+	hooks := hooksOf(jobs)
+	run := hookRun{changed: changed}
+
+	if err := hooks.run(HookPreRender, run); err != nil {
+		result.HookErr = errors.Join(err, hooks.failed(err, run))
+		return result
+	}
+
+	var errs []error
+	rendered := 0
+	for _, job := range jobs {
+		outputs, written, err := job.run(writeUnchanged)
+		result.Jobs = append(result.Jobs, JobResult{Job: job, Outputs: outputs, Written: written, Err: err})
+		run.written = append(run.written, written...)
+		rendered += len(outputs)
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		result.HookErr = hooks.failed(errors.Join(errs...), run)
+		return result
+	}
+	// Nothing to post-process when every output was already up to date
+	if len(run.written) == 0 && rendered > 0 {
+		return result
+	}
+	if err := hooks.run(HookPostWrite, run); err != nil {
+		result.HookErr = errors.Join(err, hooks.failed(err, run))
+	}
+	return result
+}
+
+// groupByTarget splits jobs into runs of consecutive jobs of the same target, keeping their order
+func groupByTarget(jobs []Job) [][]Job {
+	var groups [][]Job
+	for i, job := range jobs {
+		if i == 0 || job.Target != jobs[i-1].Target {
+			groups = append(groups, nil)
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], job)
+	}
+	return groups
+}
+
+// shellCommand returns a command running line through the platform shell
+func shellCommand(line string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", line)
+	}
+	return exec.Command("sh", "-c", line)
+}
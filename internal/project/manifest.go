@@ -0,0 +1,255 @@
+package project
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/singoesdeep/templater/internal/engine"
+)
+
+// ManifestFile is where the build manifest is kept, relative to the configuration's base directory
+const ManifestFile = ".templater/manifest.json"
+
+// manifestVersion is the format of the manifest; manifests in another format are ignored
+const manifestVersion = 1
+
+// Manifest records what each job was last generated from and what it wrote, so that
+// jobs whose inputs and outputs are unchanged can be skipped. Paths are relative to
+// the configuration's base directory, or absolute for files outside it.
+type Manifest struct {
+	Version int              `json:"version"`
+	Jobs    []*ManifestEntry `json:"jobs"`
+}
+
+// ManifestEntry records the last successful run of a job
+type ManifestEntry struct {
+	Target   string `json:"target"`
+	Template string `json:"template"`
+	// Inputs are the SHA-256 hashes of the template, its depends_on files and data files
+	Inputs map[string]string `json:"inputs"`
+	// Settings is a hash of the job's inline vars, function sets, post-processors and output
+	Settings string `json:"settings"`
+	// Funcs identifies the template functions the job was rendered with
+	Funcs string `json:"funcs"`
+	// Outputs are the SHA-256 hashes of the files written
+	Outputs map[string]string `json:"outputs"`
+}
+
+// LoadManifest reads the manifest in baseDir. A missing, unreadable or outdated
+// manifest yields an empty one, so every job is generated.
+func LoadManifest(baseDir string) *Manifest {
+	content, err := os.ReadFile(filepath.Join(baseDir, ManifestFile))
+	if err != nil {
+		return &Manifest{Version: manifestVersion}
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(content, &manifest); err != nil || manifest.Version != manifestVersion {
+		return &Manifest{Version: manifestVersion}
+	}
+	return &manifest
+}
+
+// Save writes the manifest to baseDir, replacing the previous one at once
+func (m *Manifest) Save(baseDir string) error {
+	sort.Slice(m.Jobs, func(i, j int) bool {
+		if m.Jobs[i].Target != m.Jobs[j].Target {
+			return m.Jobs[i].Target < m.Jobs[j].Target
+		}
+		return m.Jobs[i].Template < m.Jobs[j].Template
+	})
+
+	content, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error encoding manifest: %w", err)
+	}
+
+	path := filepath.Join(baseDir, ManifestFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("error creating manifest directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(content, '\n'), 0644); err != nil {
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error writing manifest: %w", err)
+	}
+	return nil
+}
+
+// lookup returns the entry of a target's template, or nil
+func (m *Manifest) lookup(target, template string) *ManifestEntry {
+	for _, entry := range m.Jobs {
+		if entry.Target == target && entry.Template == template {
+			return entry
+		}
+	}
+	return nil
+}
+
+// orphanedOutputs returns the outputs recorded in previous that no job generates any
+// more, given the entries of current. Outputs of jobs missing from current because
+// they failed are kept.
+func orphanedOutputs(previous, current *Manifest, jobs []Job, baseDir string) []string {
+	kept := make(map[string]bool)
+	for _, entry := range current.Jobs {
+		for path := range entry.Outputs {
+			kept[path] = true
+		}
+	}
+	for _, job := range jobs {
+		template := relPath(baseDir, job.Template)
+		if current.lookup(job.Target, template) != nil {
+			continue
+		}
+		if entry := previous.lookup(job.Target, template); entry != nil {
+			for path := range entry.Outputs {
+				kept[path] = true
+			}
+		}
+	}
+
+	var orphans []string
+	for _, entry := range previous.Jobs {
+		for path := range entry.Outputs {
+			if !kept[path] {
+				kept[path] = true
+				orphans = append(orphans, absPath(baseDir, path))
+			}
+		}
+	}
+	sort.Strings(orphans)
+	return orphans
+}
+
+// fingerprint records what the job is generated from, before it runs
+func (j Job) fingerprint(baseDir string) (*ManifestEntry, error) {
+	deps, err := engine.DependencyClosure(j.Template)
+	if err != nil {
+		return nil, err
+	}
+	funcs, err := engine.FuncsVersion(j.Funcs)
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &ManifestEntry{
+		Target:   j.Target,
+		Template: relPath(baseDir, j.Template),
+		Inputs:   make(map[string]string),
+		Funcs:    funcs,
+	}
+	for _, path := range append(append([]string{j.Template}, deps...), j.Data...) {
+		hash, err := hashFile(path)
+		if err != nil {
+			return nil, err
+		}
+		entry.Inputs[relPath(baseDir, path)] = hash
+	}
+
+	keys := make([]string, 0, len(j.Vars))
+	for k := range j.Vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	settings := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(settings, "var %q=%q\n", k, j.Vars[k])
+	}
+	fmt.Fprintf(settings, "funcs %q\npost_process %q\nformat %t\nheader %t\nmerge %t\noutput %q\n", j.Funcs, j.PostProcess, j.Format, j.Header, j.Merge, relPath(baseDir, j.Output))
+	if j.Header {
+		// Headers name the templater version, so an upgrade changes the outputs
+		fmt.Fprintf(settings, "version %q\n", engine.Version)
+	}
+	entry.Settings = hex.EncodeToString(settings.Sum(nil))
+
+	return entry, nil
+}
+
+// upToDate reports whether the job recorded by e can be skipped for current: it was
+// generated from the same inputs and settings and its outputs were not changed since
+func (e *ManifestEntry) upToDate(current *ManifestEntry, baseDir string) bool {
+	if e.Settings != current.Settings || e.Funcs != current.Funcs || len(e.Inputs) != len(current.Inputs) || len(e.Outputs) == 0 {
+		return false
+	}
+	for path, hash := range current.Inputs {
+		if e.Inputs[path] != hash {
+			return false
+		}
+	}
+	for path, hash := range e.Outputs {
+		if current, err := hashFile(absPath(baseDir, path)); err != nil || current != hash {
+			return false
+		}
+	}
+	return true
+}
+
+// recordOutputs sets the hashes of the outputs the job generated
+func (e *ManifestEntry) recordOutputs(baseDir string, outputs []engine.RenderedOutput) {
+	e.Outputs = make(map[string]string, len(outputs))
+	for _, output := range outputs {
+		sum := sha256.Sum256([]byte(output.Content))
+		e.Outputs[relPath(baseDir, output.Path)] = hex.EncodeToString(sum[:])
+	}
+}
+
+// recordFiles sets the hashes of the outputs the job wrote from their files, which hold
+// the merged content rather than the rendered one when hand edits were merged into them
+func (e *ManifestEntry) recordFiles(baseDir string, outputs []engine.RenderedOutput) {
+	e.recordOutputs(baseDir, outputs)
+	for _, output := range outputs {
+		if hash, err := hashFile(output.Path); err == nil {
+			e.Outputs[relPath(baseDir, output.Path)] = hash
+		}
+	}
+}
+
+// absPath turns a path recorded in the manifest back into a file path
+func absPath(baseDir, path string) string {
+	path = filepath.FromSlash(path)
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(baseDir, path)
+}
+
+// unchangedOutput reports whether output's file already holds its content
+func unchangedOutput(output engine.RenderedOutput) bool {
+	content, err := os.ReadFile(output.Path)
+	return err == nil && bytes.Equal(content, []byte(output.Content))
+}
+
+// hashFile returns the hex SHA-256 hash of a file's content
+func hashFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return "", fmt.Errorf("file not found: %s", path)
+		}
+		return "", fmt.Errorf("error reading %s: %w", path, err)
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// relPath returns path relative to baseDir with forward slashes, or path itself
+// when it lies outside baseDir
+func relPath(baseDir, path string) string {
+	rel, err := filepath.Rel(baseDir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return filepath.ToSlash(path)
+	}
+	return filepath.ToSlash(rel)
+}
@@ -0,0 +1,186 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+
+	"github.com/singoesdeep/templater/internal/config"
+	"github.com/singoesdeep/templater/internal/engine"
+	"github.com/singoesdeep/templater/internal/ui"
+)
+
+// PlanAction is what generation would do to an output
+type PlanAction string
+
+const (
+	// PlanCreate is an output that does not exist yet
+	PlanCreate PlanAction = "create"
+	// PlanUpdate is an output whose content would change
+	PlanUpdate PlanAction = "update"
+	// PlanUnchanged is an output that would keep its content
+	PlanUnchanged PlanAction = "unchanged"
+	// PlanDelete is an orphaned output that would be deleted with Prune
+	PlanDelete PlanAction = "delete"
+)
+
+// PlannedOutput is what generation would do to one output
+type PlannedOutput struct {
+	Action PlanAction `json:"action"`
+	// Target and Template are empty for deleted outputs no job generates any more
+	Target   string `json:"target,omitempty"`
+	Template string `json:"template,omitempty"`
+	Path     string `json:"path"`
+	// LinesAdded and LinesRemoved count the lines that would change
+	LinesAdded   int `json:"lines_added"`
+	LinesRemoved int `json:"lines_removed"`
+	// Diff is the unified diff from the file on disk to the new content, when requested
+	Diff string `json:"diff,omitempty"`
+}
+
+// Plan is what generating the configured targets would change
+type Plan struct {
+	Outputs []PlannedOutput `json:"outputs"`
+}
+
+// Count returns the number of planned outputs with the given action
+func (p *Plan) Count(action PlanAction) int {
+	count := 0
+	for _, output := range p.Outputs {
+		if output.Action == action {
+			count++
+		}
+	}
+	return count
+}
+
+// PlanGeneration works out what GenerateWithOptions would do with opts, without
+// writing anything or running hooks. Jobs the manifest would skip are reported as
+// unchanged without rendering them. With withDiffs, every created or updated output
+// carries its unified diff. Templates that fail to render are returned together as
+// the error, along with the plan for the others.
+func PlanGeneration(cfg *config.Config, opts GenerateOptions, withDiffs bool) (*Plan, error) {
+	jobs, err := Jobs(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	baseDir := cfg.BaseDir()
+	previous := LoadManifest(baseDir)
+	// The manifest generation would record, for finding the outputs it would orphan
+	planned := &Manifest{Version: manifestVersion}
+
+	plan := &Plan{}
+	var errs []error
+	for _, job := range jobs {
+		job.Merge = job.Merge || opts.Merge
+		entry, fingerprintErr := job.fingerprint(baseDir)
+		if fingerprintErr == nil && !opts.Force {
+			if last := previous.lookup(entry.Target, entry.Template); last != nil && last.upToDate(entry, baseDir) {
+				planned.Jobs = append(planned.Jobs, last)
+				paths := make([]string, 0, len(last.Outputs))
+				for path := range last.Outputs {
+					paths = append(paths, path)
+				}
+				sort.Strings(paths)
+				for _, path := range paths {
+					plan.Outputs = append(plan.Outputs, PlannedOutput{
+						Action:   PlanUnchanged,
+						Target:   job.Target,
+						Template: job.Template,
+						Path:     absPath(baseDir, path),
+					})
+				}
+				continue
+			}
+		}
+
+		outputs, err := job.Render()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if fingerprintErr == nil {
+			entry.recordOutputs(baseDir, outputs)
+			planned.Jobs = append(planned.Jobs, entry)
+		}
+
+		for _, output := range outputs {
+			planOutput := PlannedOutput{Target: job.Target, Template: job.Template, Path: output.Path}
+			name := relPath(baseDir, output.Path)
+			if job.Merge {
+				if output.Content, _, err = engine.MergedContent(output.Path, output.Content); err != nil {
+					errs = append(errs, fmt.Errorf("target %s: %w", job.Target, err))
+					continue
+				}
+			}
+
+			current, err := os.ReadFile(output.Path)
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				planOutput.Action = PlanCreate
+				planOutput.LinesAdded, _ = ui.DiffStats("", output.Content)
+				if withDiffs {
+					planOutput.Diff = outputDiff("/dev/null", name, "", output.Content)
+				}
+			case err != nil:
+				errs = append(errs, fmt.Errorf("target %s: error reading %s: %w", job.Target, output.Path, err))
+				continue
+			case string(current) == output.Content:
+				planOutput.Action = PlanUnchanged
+			default:
+				planOutput.Action = PlanUpdate
+				planOutput.LinesAdded, planOutput.LinesRemoved = ui.DiffStats(string(current), output.Content)
+				if withDiffs {
+					planOutput.Diff = outputDiff(name, name+" (generated)", string(current), output.Content)
+				}
+			}
+			plan.Outputs = append(plan.Outputs, planOutput)
+		}
+	}
+
+	if opts.Prune {
+		for _, path := range orphanedOutputs(previous, planned, jobs, baseDir) {
+			current, err := os.ReadFile(path)
+			if err != nil {
+				// Already gone
+				continue
+			}
+			planOutput := PlannedOutput{Action: PlanDelete, Path: path}
+			_, planOutput.LinesRemoved = ui.DiffStats(string(current), "")
+			if withDiffs {
+				planOutput.Diff = outputDiff(relPath(baseDir, path), "/dev/null", string(current), "")
+			}
+			plan.Outputs = append(plan.Outputs, planOutput)
+		}
+	}
+
+	return plan, errors.Join(errs...)
+}
+
+// Print prints the plan as a table of outputs with their line changes, followed by
+// the diffs it carries and a summary
+func (p *Plan) Print(baseDir string) {
+	rows := make([][]string, len(p.Outputs))
+	for i, output := range p.Outputs {
+		rows[i] = []string{
+			string(output.Action),
+			relPath(baseDir, output.Path),
+			fmt.Sprintf("+%d -%d", output.LinesAdded, output.LinesRemoved),
+		}
+	}
+	ui.PrintTable([]string{"ACTION", "OUTPUT", "LINES"}, rows)
+
+	for _, output := range p.Outputs {
+		if output.Diff != "" {
+			fmt.Println()
+			ui.PrintColoredDiff(output.Diff)
+		}
+	}
+
+	fmt.Println()
+	ui.PrintInfo("Plan: %d to create, %d to update, %d unchanged, %d to delete",
+		p.Count(PlanCreate), p.Count(PlanUpdate), p.Count(PlanUnchanged), p.Count(PlanDelete))
+}
@@ -0,0 +1,24 @@
+package project
+
+import (
+	"path/filepath"
+
+	"github.com/singoesdeep/templater/internal/config"
+	"github.com/singoesdeep/templater/internal/plugin"
+)
+
+// LoadPlugins starts the external plugins in the configured plugin directory and
+// registers them with the default plugin registry, so targets can use them. Plugins
+// loaded before are kept; plugins that fail to load are reported together.
+func LoadPlugins(cfg *config.Config) error {
+	timeout, err := cfg.GetPluginTimeoutDuration()
+	if err != nil {
+		return err
+	}
+
+	dir := cfg.GetPluginDir()
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(cfg.BaseDir(), dir)
+	}
+	return plugin.Default.LoadDir(dir, plugin.ExternalOptions{Timeout: timeout})
+}
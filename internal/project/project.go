@@ -0,0 +1,551 @@
+package project
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/singoesdeep/templater/internal/config"
+	"github.com/singoesdeep/templater/internal/engine"
+	"github.com/singoesdeep/templater/internal/reliability"
+)
+
+// Job is a single template rendered to its outputs as part of a target
+type Job struct {
+	// Target is the name of the target the job belongs to
+	Target string
+	// Template is the template file
+	Template string
+	// Data are the data files merged in order, later files winning
+	Data []string
+	// Vars are inline values merged over the data files
+	Vars map[string]string
+	// Output is the output file, or the output directory for templates with an output directive
+	Output string
+	// Funcs names additional template function sets to enable
+	Funcs []string
+	// PostProcess names the post-processors applied to each output
+	PostProcess []string
+	// Format applies the built-in formatters for each output's extension after the post-processors
+	Format bool
+	// Header adds a generated file header with the job's provenance to each output
+	Header bool
+	// Merge merges hand edits to the outputs with regenerated content
+	Merge bool
+	// Hooks are commands run around the job's generation
+	Hooks config.Hooks
+	// Dir is the directory hooks run in
+	Dir string
+}
+
+// Jobs expands the configured targets into jobs, resolving template globs and
+// directories and relative paths against the configuration's base directory.
+// A glob or directory without templates expands to no jobs.
+func Jobs(cfg *config.Config) ([]Job, error) {
+	baseDir := cfg.BaseDir()
+	resolve := func(path string) string {
+		if path == "" || filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(baseDir, path)
+	}
+
+	var jobs []Job
+	for i, target := range cfg.Targets {
+		name := target.Name
+		if name == "" {
+			name = fmt.Sprintf("targets[%d]", i)
+		}
+		if target.Template == "" {
+			return nil, fmt.Errorf("target %s: template is required", name)
+		}
+
+		data := []string{}
+		if target.Data != "" {
+			data = append(data, resolve(target.Data))
+		}
+		for _, overlay := range target.Overlays {
+			data = append(data, resolve(overlay))
+		}
+
+		job := Job{
+			Target:      name,
+			Data:        data,
+			Vars:        target.Vars,
+			Funcs:       target.Funcs,
+			PostProcess: target.PostProcess,
+			Format:      target.Format != "none",
+			Header:      target.Header,
+			Merge:       target.Merge,
+			Hooks:       target.Hooks,
+			Dir:         baseDir,
+		}
+
+		pattern := resolve(target.Template)
+		templates, expanded, err := expandTemplates(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %w", name, err)
+		}
+
+		if !expanded {
+			job.Template = pattern
+			job.Output = resolve(target.Output)
+			if job.Output == "" {
+				output, err := defaultOutput(pattern, resolve(cfg.GetOutputDir()))
+				if err != nil {
+					return nil, fmt.Errorf("target %s: %w", name, err)
+				}
+				job.Output = output
+			}
+			jobs = append(jobs, job)
+			continue
+		}
+
+		// With a glob or directory, the output is the directory the matched templates render into
+		outputDir := resolve(target.Output)
+		if outputDir == "" {
+			outputDir = resolve(cfg.GetOutputDir())
+		}
+		for _, tmpl := range templates {
+			output, err := defaultOutput(tmpl.path, filepath.Join(outputDir, tmpl.relDir))
+			if err != nil {
+				return nil, fmt.Errorf("target %s: %w", name, err)
+			}
+			job.Template = tmpl.path
+			job.Output = output
+			jobs = append(jobs, job)
+		}
+	}
+
+	return jobs, nil
+}
+
+// GenerateOptions control how Generate runs the configured targets
+type GenerateOptions struct {
+	// Force generates every job and rewrites every output, ignoring the manifest
+	Force bool
+	// Prune backs up and deletes outputs recorded in the manifest that no job generates
+	// any more, because their template or output directive item was removed
+	Prune bool
+	// Merge merges hand edits into the outputs of every job, as if each target set merge
+	Merge bool
+}
+
+// GenerateResult reports what a generation did
+type GenerateResult struct {
+	// Written are the outputs written
+	Written []string
+	// Unchanged are the outputs rendered identical to the file on disk, which were left untouched
+	Unchanged []string
+	// Skipped are the jobs whose inputs and outputs had not changed since the last generation
+	Skipped []Job
+	// Deleted are the orphaned outputs removed with Prune
+	Deleted []string
+}
+
+// Generate runs every job of the configured targets and returns the written paths.
+// A failing job does not stop the others; all failures are returned together.
+// Generation is incremental, see GenerateWithOptions.
+func Generate(cfg *config.Config) ([]string, error) {
+	result, err := GenerateWithOptions(cfg, GenerateOptions{})
+	if result == nil {
+		return nil, err
+	}
+	return result.Written, err
+}
+
+// GenerateWithOptions runs the jobs of the configured targets incrementally. Jobs whose
+// template, depends_on files, data files, settings and template functions are unchanged
+// since the manifest recorded them, and whose outputs were not modified since, are
+// skipped unless Force is set. Outputs rendered identical to the file on disk are not rewritten, so they
+// keep their modification time and get no backup. The manifest is updated with the
+// jobs that succeeded; failed jobs are generated again next time.
+func GenerateWithOptions(cfg *config.Config, opts GenerateOptions) (*GenerateResult, error) {
+	jobs, err := Jobs(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	baseDir := cfg.BaseDir()
+	previous := LoadManifest(baseDir)
+	manifest := &Manifest{Version: manifestVersion}
+
+	result := &GenerateResult{}
+	var errs []error
+	for _, targetJobs := range groupByTarget(jobs) {
+		var pending []Job
+		var changed []string
+		entries := make(map[string]*ManifestEntry)
+		for _, job := range targetJobs {
+			job.Merge = job.Merge || opts.Merge
+			// Without a fingerprint, e.g. for a missing template, the job runs and reports the problem
+			entry, fingerprintErr := job.fingerprint(baseDir)
+			if fingerprintErr == nil && !opts.Force {
+				if last := previous.lookup(entry.Target, entry.Template); last != nil && last.upToDate(entry, baseDir) {
+					manifest.Jobs = append(manifest.Jobs, last)
+					result.Skipped = append(result.Skipped, job)
+					continue
+				}
+			}
+			if fingerprintErr == nil {
+				entries[job.Template] = entry
+			}
+
+			pending = append(pending, job)
+			changed = append(changed, job.Inputs()...)
+		}
+		if len(pending) == 0 {
+			continue
+		}
+
+		run := runTarget(pending, changed, opts.Force)
+		if err := run.Err(); err != nil {
+			errs = append(errs, err)
+		}
+		for _, jobResult := range run.Jobs {
+			// Paths are returned even when a post_write hook failed
+			result.Written = append(result.Written, jobResult.Written...)
+			for _, output := range jobResult.Outputs {
+				if !slices.Contains(jobResult.Written, output.Path) {
+					result.Unchanged = append(result.Unchanged, output.Path)
+				}
+			}
+			// Jobs of a target whose hooks failed are generated again next time
+			entry := entries[jobResult.Job.Template]
+			if jobResult.Err != nil || run.HookErr != nil || entry == nil {
+				continue
+			}
+			entry.recordFiles(baseDir, jobResult.Outputs)
+			manifest.Jobs = append(manifest.Jobs, entry)
+		}
+	}
+
+	if opts.Prune {
+		for _, path := range orphanedOutputs(previous, manifest, jobs, baseDir) {
+			if err := reliability.BackupFile(path); err != nil {
+				errs = append(errs, fmt.Errorf("error backing up orphaned output %s: %w", path, err))
+				continue
+			}
+			if err := os.Remove(path); err != nil {
+				if !os.IsNotExist(err) {
+					errs = append(errs, fmt.Errorf("error removing orphaned output %s: %w", path, err))
+				}
+				continue
+			}
+			result.Deleted = append(result.Deleted, path)
+		}
+	}
+
+	if err := manifest.Save(baseDir); err != nil {
+		errs = append(errs, err)
+	}
+	return result, errors.Join(errs...)
+}
+
+// Inputs returns the files the job reads
+func (j Job) Inputs() []string {
+	return append([]string{j.Template}, j.Data...)
+}
+
+// LoadData loads and merges the job's data files and inline vars
+func (j Job) LoadData() (map[string]string, error) {
+	data := make(map[string]string)
+	for _, path := range j.Data {
+		fileData, err := engine.LoadData(path)
+		if err != nil {
+			return nil, fmt.Errorf("error loading data: %w", err)
+		}
+		for k, v := range fileData {
+			data[k] = v
+		}
+	}
+	for k, v := range j.Vars {
+		data[k] = v
+	}
+	return data, nil
+}
+
+// Render renders the job's outputs without writing them
+func (j Job) Render() ([]engine.RenderedOutput, error) {
+	outputs, _, err := j.render()
+	return outputs, err
+}
+
+// Run renders and writes the job's outputs, running its target's hooks around it as if
+// it were the target's only job, and returns the written paths
+func (j Job) Run() ([]string, error) {
+	outputs, err := j.RunChanged(j.Inputs())
+
+	// Paths are returned even when a post_write hook failed
+	var paths []string
+	if outputs != nil {
+		paths = make([]string, len(outputs))
+		for i, output := range outputs {
+			paths[i] = output.Path
+		}
+	}
+	return paths, err
+}
+
+// RunChanged is like Run, passing changed as the changed files to the hooks, and
+// returns the written outputs. They are returned even when a post_write hook fails.
+func (j Job) RunChanged(changed []string) ([]engine.RenderedOutput, error) {
+	run := RunTarget([]Job{j}, changed)
+	if len(run.Jobs) == 0 {
+		return nil, run.Err()
+	}
+	return run.Jobs[0].Outputs, run.Err()
+}
+
+// run renders the job and writes its outputs, without running hooks. Unless
+// writeUnchanged is set, outputs whose file already holds their content are not
+// rewritten. It returns every rendered output and the paths written.
+func (j Job) run(writeUnchanged bool) ([]engine.RenderedOutput, []string, error) {
+	outputs, outputDir, err := j.render()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pending := outputs
+	if !writeUnchanged {
+		pending = nil
+		for _, output := range outputs {
+			if !unchangedOutput(output) {
+				pending = append(pending, output)
+			}
+		}
+	}
+	// Outputs were already formatted when rendered
+	if err := engine.WriteOutputsWithOptions(pending, outputDir, engine.WriteOptions{Merge: j.Merge}); err != nil {
+		return nil, nil, fmt.Errorf("target %s: %w", j.Target, err)
+	}
+
+	written := make([]string, len(pending))
+	for i, output := range pending {
+		written[i] = output.Path
+	}
+	return outputs, written, nil
+}
+
+// render renders the job's outputs and returns the directory they must stay within
+func (j Job) render() ([]engine.RenderedOutput, string, error) {
+	data, err := j.LoadData()
+	if err != nil {
+		return nil, "", fmt.Errorf("target %s: %w", j.Target, err)
+	}
+
+	directive, err := engine.GetOutputDirective(j.Template)
+	if err != nil {
+		return nil, "", fmt.Errorf("target %s: %w", j.Target, err)
+	}
+
+	var outputs []engine.RenderedOutput
+	var outputDir string
+	if directive != nil {
+		outputDir = j.Output
+		outputs, err = engine.RenderOutputsWithFuncs(j.Template, data, outputDir, j.Funcs)
+		if err != nil {
+			return nil, "", fmt.Errorf("target %s: %w", j.Target, err)
+		}
+	} else {
+		outputDir = filepath.Dir(j.Output)
+		result, err := engine.RenderTemplateWithFuncs(j.Template, data, j.Funcs)
+		if err != nil {
+			return nil, "", fmt.Errorf("target %s: error rendering %s: %w", j.Target, j.Template, err)
+		}
+		outputs = []engine.RenderedOutput{{Path: j.Output, Content: result}}
+	}
+
+	for i := range outputs {
+		outputs[i].Content, err = engine.ApplyPostProcessors(j.PostProcess, outputs[i].Path, outputs[i].Content)
+		if err != nil {
+			return nil, "", fmt.Errorf("target %s: %w", j.Target, err)
+		}
+		if j.Format {
+			outputs[i].Content, err = engine.FormatOutput(outputs[i].Path, outputs[i].Content)
+			if err != nil {
+				return nil, "", fmt.Errorf("target %s: %w", j.Target, err)
+			}
+		}
+		if j.Header {
+			outputs[i].Content = engine.AddHeader(outputs[i].Path, outputs[i].Content, j.provenance())
+		}
+		// Outputs are compared with the files on disk including their protected regions
+		outputs[i].Content, _, err = engine.PreserveFileRegions(outputs[i].Path, outputs[i].Content)
+		if err != nil {
+			return nil, "", fmt.Errorf("target %s: %w", j.Target, err)
+		}
+	}
+
+	return outputs, outputDir, nil
+}
+
+// provenance returns the sources recorded in the headers of the job's outputs
+func (j Job) provenance() engine.Provenance {
+	p := engine.Provenance{Template: relPath(j.Dir, j.Template)}
+	for _, data := range j.Data {
+		p.Data = append(p.Data, relPath(j.Dir, data))
+	}
+	return p
+}
+
+// defaultOutput returns where a template renders to inside outputDir: the directory itself
+// for templates with an output directive, otherwise the template name without its extension
+func defaultOutput(templatePath, outputDir string) (string, error) {
+	directive, err := engine.GetOutputDirective(templatePath)
+	if err != nil {
+		return "", err
+	}
+	if directive != nil {
+		return outputDir, nil
+	}
+
+	name := filepath.Base(templatePath)
+	switch filepath.Ext(name) {
+	case ".tmpl", ".tpl", ".gotmpl":
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	}
+	return filepath.Join(outputDir, name), nil
+}
+
+// WatchDirs returns the existing directories holding the targets' templates and data.
+// Template directories are included with all their subdirectories, and globs with
+// the deepest directory above their first wildcard, so new templates are noticed.
+func WatchDirs(cfg *config.Config) []string {
+	baseDir := cfg.BaseDir()
+	resolve := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(baseDir, path)
+	}
+
+	seen := make(map[string]bool)
+	var dirs []string
+	add := func(dir string) {
+		dir = filepath.Clean(dir)
+		if seen[dir] {
+			return
+		}
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return
+		}
+		seen[dir] = true
+		dirs = append(dirs, dir)
+	}
+
+	for _, target := range cfg.Targets {
+		if target.Template != "" {
+			pattern := resolve(target.Template)
+			switch {
+			case isGlob(pattern):
+				add(globRoot(pattern))
+				// Wildcards in directory names need each matched directory watched too
+				if matches, err := filepath.Glob(pattern); err == nil {
+					for _, match := range matches {
+						add(filepath.Dir(match))
+					}
+				}
+			case isDir(pattern):
+				filepath.WalkDir(pattern, func(path string, d fs.DirEntry, err error) error {
+					if err != nil || !d.IsDir() {
+						return nil
+					}
+					if path != pattern && strings.HasPrefix(d.Name(), ".") {
+						return filepath.SkipDir
+					}
+					add(path)
+					return nil
+				})
+			default:
+				add(filepath.Dir(pattern))
+			}
+		}
+
+		for _, data := range append([]string{target.Data}, target.Overlays...) {
+			if data != "" {
+				add(filepath.Dir(resolve(data)))
+			}
+		}
+	}
+
+	return dirs
+}
+
+// templateMatch is a template found by expanding a glob or directory
+type templateMatch struct {
+	path string
+	// relDir is the template's directory relative to the expanded directory
+	relDir string
+}
+
+// expandTemplates expands a template glob or directory; expanded is false for a single template file
+func expandTemplates(pattern string) (matches []templateMatch, expanded bool, err error) {
+	switch {
+	case isGlob(pattern):
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, true, fmt.Errorf("invalid template pattern: %w", err)
+		}
+		for _, path := range paths {
+			if !isDir(path) {
+				matches = append(matches, templateMatch{path: path})
+			}
+		}
+		return matches, true, nil
+
+	case isDir(pattern):
+		err := filepath.WalkDir(pattern, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			// Skip hidden files and directories such as .templater_backups
+			if path != pattern && strings.HasPrefix(d.Name(), ".") {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+			rel, err := filepath.Rel(pattern, filepath.Dir(path))
+			if err != nil {
+				return err
+			}
+			matches = append(matches, templateMatch{path: path, relDir: rel})
+			return nil
+		})
+		if err != nil {
+			return nil, true, fmt.Errorf("error reading template directory: %w", err)
+		}
+		return matches, true, nil
+
+	default:
+		return nil, false, nil
+	}
+}
+
+// globRoot returns the deepest directory of pattern that contains no glob metacharacters
+func globRoot(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for isGlob(dir) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
+
+// isDir reports whether path is an existing directory
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// isGlob reports whether path contains glob metacharacters
+func isGlob(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
@@ -134,3 +134,35 @@ func CleanupOldBackups(filePath string, maxAge time.Duration) error {
 
 	return nil
 }
+
+// SaveGenerated stores content as the last generated version of a file, the base
+// of three-way merges with later hand edits
+func SaveGenerated(filePath string, content []byte) error {
+	backupDir := filepath.Join(filepath.Dir(filePath), ".templater_backups")
+	if err := os.MkdirAll(backupDir, DirModeReadWrite); err != nil {
+		return fmt.Errorf("error creating backup directory: %w", err)
+	}
+
+	if err := os.WriteFile(generatedPath(filePath), content, FileModeReadWrite); err != nil {
+		return fmt.Errorf("error writing generated version: %w", err)
+	}
+	return nil
+}
+
+// LoadGenerated returns the last generated version of a file stored by SaveGenerated,
+// or nil if none was stored
+func LoadGenerated(filePath string) ([]byte, error) {
+	content, err := os.ReadFile(generatedPath(filePath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading generated version: %w", err)
+	}
+	return content, nil
+}
+
+// generatedPath returns where the last generated version of a file is stored
+func generatedPath(filePath string) string {
+	return filepath.Join(filepath.Dir(filePath), ".templater_backups", filepath.Base(filePath)+".generated")
+}
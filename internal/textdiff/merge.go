@@ -0,0 +1,111 @@
+package textdiff
+
+import "strings"
+
+// Conflict markers written by Merge
+const (
+	ConflictStart = "<<<<<<<"
+	ConflictSep   = "======="
+	ConflictEnd   = ">>>>>>>"
+)
+
+// Merge combines the changes made to base in ours and in theirs, line by line. Where
+// both changed the same lines differently, both versions are kept between conflict
+// markers labelled oursLabel and theirsLabel. It returns the merged text and the
+// number of conflicts.
+func Merge(base, ours, theirs, oursLabel, theirsLabel string) (string, int) {
+	baseLines := SplitLines(base)
+	oursLines := SplitLines(ours)
+	theirsLines := SplitLines(theirs)
+	inOurs := matches(baseLines, oursLines)
+	inTheirs := matches(baseLines, theirsLines)
+
+	var b strings.Builder
+	conflicts := 0
+	i, j, k := 0, 0, 0
+	for {
+		// Find the next base line kept by both sides, where they are in sync again
+		s := i
+		for s < len(baseLines) && (inOurs[s] < 0 || inTheirs[s] < 0) {
+			s++
+		}
+		oursEnd, theirsEnd := len(oursLines), len(theirsLines)
+		if s < len(baseLines) {
+			oursEnd, theirsEnd = inOurs[s], inTheirs[s]
+		}
+
+		baseChunk := baseLines[i:s]
+		oursChunk := oursLines[j:oursEnd]
+		theirsChunk := theirsLines[k:theirsEnd]
+		switch {
+		case equalLines(oursChunk, baseChunk):
+			writeLines(&b, theirsChunk)
+		case equalLines(theirsChunk, baseChunk), equalLines(oursChunk, theirsChunk):
+			writeLines(&b, oursChunk)
+		default:
+			conflicts++
+			writeMarker(&b, ConflictStart+" "+oursLabel)
+			writeLines(&b, oursChunk)
+			writeMarker(&b, ConflictSep)
+			writeLines(&b, theirsChunk)
+			writeMarker(&b, ConflictEnd+" "+theirsLabel)
+		}
+
+		if s == len(baseLines) {
+			return b.String(), conflicts
+		}
+		b.WriteString(baseLines[s])
+		i, j, k = s+1, oursEnd+1, theirsEnd+1
+	}
+}
+
+// matches returns, for each line of a, the index of the line of b it is kept as,
+// or -1 when it was removed
+func matches(a, b []string) []int {
+	result := make([]int, len(a))
+	i, j := 0, 0
+	for _, op := range Lines(a, b, true) {
+		switch op.Kind {
+		case Keep:
+			result[i] = j
+			i++
+			j++
+		case Remove:
+			result[i] = -1
+			i++
+		case Add:
+			j++
+		}
+	}
+	return result
+}
+
+// equalLines reports whether a and b hold the same lines
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeLines writes lines as they are
+func writeLines(b *strings.Builder, lines []string) {
+	for _, line := range lines {
+		b.WriteString(line)
+	}
+}
+
+// writeMarker writes a conflict marker on a line of its own, ending the text before
+// it with a newline if it has none
+func writeMarker(b *strings.Builder, marker string) {
+	if b.Len() > 0 && !strings.HasSuffix(b.String(), "\n") {
+		b.WriteString("\n")
+	}
+	b.WriteString(marker)
+	b.WriteString("\n")
+}
@@ -0,0 +1,107 @@
+package textdiff
+
+import "testing"
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name               string
+		base, ours, theirs string
+		want               string
+		wantConflicts      int
+	}{
+		{
+			name:   "unchanged",
+			base:   "a\nb\n",
+			ours:   "a\nb\n",
+			theirs: "a\nb\n",
+			want:   "a\nb\n",
+		},
+		{
+			name:   "only ours changed",
+			base:   "a\nb\nc\n",
+			ours:   "a\nB\nc\n",
+			theirs: "a\nb\nc\n",
+			want:   "a\nB\nc\n",
+		},
+		{
+			name:   "only theirs changed",
+			base:   "a\nb\nc\n",
+			ours:   "a\nb\nc\n",
+			theirs: "a\nb\nc\nd\n",
+			want:   "a\nb\nc\nd\n",
+		},
+		{
+			name:   "separate changes",
+			base:   "a\nb\nc\nd\ne\n",
+			ours:   "A\nb\nc\nd\ne\n",
+			theirs: "a\nb\nc\nd\nE\n",
+			want:   "A\nb\nc\nd\nE\n",
+		},
+		{
+			name:   "insertions on both sides",
+			base:   "a\nb\nc\n",
+			ours:   "a\nours\nb\nc\n",
+			theirs: "a\nb\ntheirs\nc\n",
+			want:   "a\nours\nb\ntheirs\nc\n",
+		},
+		{
+			name:   "same change on both sides",
+			base:   "a\nb\nc\n",
+			ours:   "a\nx\nc\n",
+			theirs: "a\nx\nc\n",
+			want:   "a\nx\nc\n",
+		},
+		{
+			name:   "removal on one side",
+			base:   "a\nb\nc\n",
+			ours:   "a\nc\n",
+			theirs: "a\nb\nc\nd\n",
+			want:   "a\nc\nd\n",
+		},
+		{
+			name:          "overlapping changes",
+			base:          "a\nb\nc\n",
+			ours:          "a\nours\nc\n",
+			theirs:        "a\ntheirs\nc\n",
+			want:          "a\n<<<<<<< current\nours\n=======\ntheirs\n>>>>>>> generated\nc\n",
+			wantConflicts: 1,
+		},
+		{
+			name:          "two conflicts",
+			base:          "a\nb\nc\nd\ne\n",
+			ours:          "1\nb\nc\nd\n2\n",
+			theirs:        "3\nb\nc\nd\n4\n",
+			want:          "<<<<<<< current\n1\n=======\n3\n>>>>>>> generated\nb\nc\nd\n<<<<<<< current\n2\n=======\n4\n>>>>>>> generated\n",
+			wantConflicts: 2,
+		},
+		{
+			name:          "empty base",
+			ours:          "ours\n",
+			theirs:        "theirs\n",
+			want:          "<<<<<<< current\nours\n=======\ntheirs\n>>>>>>> generated\n",
+			wantConflicts: 1,
+		},
+		{
+			name:   "empty base with the same content",
+			ours:   "a\n",
+			theirs: "a\n",
+			want:   "a\n",
+		},
+		{
+			name:          "conflict without trailing newlines",
+			base:          "a\n",
+			ours:          "a\nours",
+			theirs:        "a\ntheirs",
+			want:          "a\n<<<<<<< current\nours\n=======\ntheirs\n>>>>>>> generated\n",
+			wantConflicts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, conflicts := Merge(tt.base, tt.ours, tt.theirs, "current", "generated")
+			if got != tt.want || conflicts != tt.wantConflicts {
+				t.Errorf("Merge = %q with %d conflicts, want %q with %d", got, conflicts, tt.want, tt.wantConflicts)
+			}
+		})
+	}
+}
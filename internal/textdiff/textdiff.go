@@ -0,0 +1,228 @@
+package textdiff
+
+import "strings"
+
+// maxEditCost bounds the work spent finding a minimal diff; beyond it, the differing
+// middle of the texts is shown as removed and added as a whole
+const maxEditCost = 2000
+
+// Op kinds
+const (
+	Keep   byte = ' '
+	Remove byte = '-'
+	Add    byte = '+'
+)
+
+// Op is a line kept, removed or added
+type Op struct {
+	Kind byte // Keep, Remove or Add
+	Line string
+}
+
+// SplitLines splits s into lines, each keeping its newline
+func SplitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// Lines returns the operations turning a into b, diffing the lines between their
+// common prefix and suffix with Myers' algorithm, or with patience diff when
+// usePatience is set
+func Lines(a, b []string, usePatience bool) []Op {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	ops := make([]Op, 0, len(a)+len(b))
+	for _, line := range a[:prefix] {
+		ops = append(ops, Op{Keep, line})
+	}
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if usePatience {
+		ops = append(ops, patience(middleA, middleB)...)
+	} else {
+		ops = append(ops, myers(middleA, middleB)...)
+	}
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, Op{Keep, line})
+	}
+	return ops
+}
+
+// patience diffs a and b around the longest sequence of lines that occur exactly once
+// in each, in the same order, diffing between them recursively. Without such lines
+// it falls back to Myers' algorithm.
+func patience(a, b []string) []Op {
+	anchors := uniqueAnchors(a, b)
+	if len(anchors) == 0 {
+		return myers(a, b)
+	}
+
+	var ops []Op
+	i, j := 0, 0
+	for _, anchor := range anchors {
+		ops = append(ops, Lines(a[i:anchor.a], b[j:anchor.b], true)...)
+		ops = append(ops, Op{Keep, a[anchor.a]})
+		i, j = anchor.a+1, anchor.b+1
+	}
+	return append(ops, Lines(a[i:], b[j:], true)...)
+}
+
+// anchor is a line matched between the two texts of a patience diff
+type anchor struct {
+	a, b int
+}
+
+// uniqueAnchors returns the longest increasing sequence of lines unique to both a and b
+func uniqueAnchors(a, b []string) []anchor {
+	counts := make(map[string][2]int)
+	positions := make(map[string]int)
+	for _, line := range a {
+		c := counts[line]
+		c[0]++
+		counts[line] = c
+	}
+	for j, line := range b {
+		c := counts[line]
+		c[1]++
+		counts[line] = c
+		positions[line] = j
+	}
+
+	var candidates []anchor
+	for i, line := range a {
+		if c := counts[line]; c[0] == 1 && c[1] == 1 {
+			candidates = append(candidates, anchor{i, positions[line]})
+		}
+	}
+
+	// Patience sorting: piles hold the candidates ending the increasing sequences of
+	// each length, with links back to their predecessors
+	var piles []int
+	prev := make([]int, len(candidates))
+	for k, candidate := range candidates {
+		lo, hi := 0, len(piles)
+		for lo < hi {
+			mid := (lo + hi) / 2
+			if candidates[piles[mid]].b < candidate.b {
+				lo = mid + 1
+			} else {
+				hi = mid
+			}
+		}
+		prev[k] = -1
+		if lo > 0 {
+			prev[k] = piles[lo-1]
+		}
+		if lo == len(piles) {
+			piles = append(piles, k)
+		} else {
+			piles[lo] = k
+		}
+	}
+	if len(piles) == 0 {
+		return nil
+	}
+
+	anchors := make([]anchor, len(piles))
+	for k, n := piles[len(piles)-1], len(piles)-1; k >= 0; k, n = prev[k], n-1 {
+		anchors[n] = candidates[k]
+	}
+	return anchors
+}
+
+// myers returns a shortest edit script turning a into b
+func myers(a, b []string) []Op {
+	n, m := len(a), len(b)
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+
+	// trace[d] holds v for diagonals -d..d before step d, for backtracking
+	var trace [][]int
+	found := false
+	for d := 0; d <= n+m && !found; d++ {
+		if d > maxEditCost {
+			return replaceAll(a, b)
+		}
+		trace = append(trace, append([]int(nil), v[offset-d:offset+d+1]...))
+
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+	}
+
+	var ops []Op
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d]
+		at := func(k int) int { return prev[k+d] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			ops = append(ops, Op{Keep, a[x-1]})
+			x--
+			y--
+		}
+		if x == prevX {
+			ops = append(ops, Op{Add, b[y-1]})
+			y--
+		} else {
+			ops = append(ops, Op{Remove, a[x-1]})
+			x--
+		}
+	}
+	for x > 0 && y > 0 {
+		ops = append(ops, Op{Keep, a[x-1]})
+		x--
+		y--
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
+
+// replaceAll returns the operations removing all of a and adding all of b
+func replaceAll(a, b []string) []Op {
+	ops := make([]Op, 0, len(a)+len(b))
+	for _, line := range a {
+		ops = append(ops, Op{Remove, line})
+	}
+	for _, line := range b {
+		ops = append(ops, Op{Add, line})
+	}
+	return ops
+}
@@ -0,0 +1,145 @@
+package textdiff
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+// apply returns the texts an edit script turns from and into
+func apply(ops []Op) (a, b []string) {
+	for _, op := range ops {
+		if op.Kind != Add {
+			a = append(a, op.Line)
+		}
+		if op.Kind != Remove {
+			b = append(b, op.Line)
+		}
+	}
+	return a, b
+}
+
+// count returns the number of operations of the given kind
+func count(ops []Op, kind byte) int {
+	n := 0
+	for _, op := range ops {
+		if op.Kind == kind {
+			n++
+		}
+	}
+	return n
+}
+
+// numbered returns n lines "<prefix><i>\n"
+func numbered(prefix string, n int) []string {
+	lines := make([]string, n)
+	for i := range lines {
+		lines[i] = fmt.Sprintf("%s%d\n", prefix, i)
+	}
+	return lines
+}
+
+func TestSplitLines(t *testing.T) {
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{"", nil},
+		{"\n", []string{"\n"}},
+		{"a", []string{"a"}},
+		{"a\n", []string{"a\n"}},
+		{"a\nb", []string{"a\n", "b"}},
+		{"a\n\nb\n", []string{"a\n", "\n", "b\n"}},
+	}
+	for _, tt := range tests {
+		if got := SplitLines(tt.in); !slices.Equal(got, tt.want) {
+			t.Errorf("SplitLines(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		a, b     []string
+		patience bool
+		// removed and added are the expected numbers of removed and added lines
+		removed, added int
+	}{
+		{name: "both empty"},
+		{name: "empty old", b: []string{"a\n", "b\n"}, added: 2},
+		{name: "empty new", a: []string{"a\n", "b\n"}, removed: 2},
+		{name: "equal", a: []string{"a\n", "b\n"}, b: []string{"a\n", "b\n"}},
+		{name: "insert", a: []string{"a\n", "c\n"}, b: []string{"a\n", "b\n", "c\n"}, added: 1},
+		{name: "replace", a: []string{"a\n", "b\n", "c\n"}, b: []string{"a\n", "x\n", "c\n"}, removed: 1, added: 1},
+		{name: "missing trailing newline added", a: []string{"a\n", "b"}, b: []string{"a\n", "b\n"}, removed: 1, added: 1},
+		{name: "missing trailing newline removed", a: []string{"a\n"}, b: []string{"a"}, removed: 1, added: 1},
+		{
+			name:     "patience",
+			a:        []string{"func a() {\n", "}\n", "\n", "func b() {\n", "}\n"},
+			b:        []string{"func a() {\n", "}\n", "\n", "func c() {\n", "}\n", "\n", "func b() {\n", "}\n"},
+			patience: true,
+			added:    3,
+		},
+		{
+			name:     "patience without unique anchors",
+			a:        []string{"x\n", "x\n", "y\n", "y\n"},
+			b:        []string{"y\n", "y\n", "x\n", "x\n"},
+			patience: true,
+			removed:  2,
+			added:    2,
+		},
+		{
+			name:     "patience on empty old",
+			b:        []string{"a\n"},
+			patience: true,
+			added:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ops := Lines(tt.a, tt.b, tt.patience)
+			a, b := apply(ops)
+			if !slices.Equal(a, tt.a) || !slices.Equal(b, tt.b) {
+				t.Fatalf("ops %q turn %q into %q, want %q into %q", ops, a, b, tt.a, tt.b)
+			}
+			if removed, added := count(ops, Remove), count(ops, Add); removed != tt.removed || added != tt.added {
+				t.Errorf("removed %d and added %d lines, want %d and %d: %q", removed, added, tt.removed, tt.added, ops)
+			}
+		})
+	}
+}
+
+func TestLinesMaxEditCost(t *testing.T) {
+	// The shortest edit script keeps the shared line, but needs more than maxEditCost
+	// edits to find it
+	a := append(append(numbered("a", maxEditCost), "shared\n"), numbered("c", maxEditCost)...)
+	b := append(append(numbered("b", maxEditCost), "shared\n"), numbered("d", maxEditCost)...)
+
+	ops := Lines(a, b, false)
+	gotA, gotB := apply(ops)
+	if !slices.Equal(gotA, a) || !slices.Equal(gotB, b) {
+		t.Fatal("fallback ops do not turn a into b")
+	}
+	if n := count(ops, Keep); n != 0 {
+		t.Errorf("fallback kept %d lines, want every line removed and added", n)
+	}
+	for i, op := range ops {
+		want := Remove
+		if i >= len(a) {
+			want = Add
+		}
+		if op.Kind != want {
+			t.Fatalf("op %d is %q, want every removal before the additions", i, op.Kind)
+		}
+	}
+}
+
+func TestLinesWithinMaxEditCost(t *testing.T) {
+	a := append(append(numbered("a", 10), "shared\n"), numbered("c", 10)...)
+	b := append(append(numbered("b", 10), "shared\n"), numbered("d", 10)...)
+
+	if n := count(Lines(a, b, false), Keep); n != 1 {
+		t.Errorf("kept %d lines, want the shared line kept", n)
+	}
+}
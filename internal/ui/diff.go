@@ -0,0 +1,334 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/fatih/color"
+	"github.com/singoesdeep/templater/internal/textdiff"
+)
+
+// defaultDiffContext is the number of unchanged lines shown around each change
+const defaultDiffContext = 3
+
+// Colors of diffs, always enabled since callers decide whether to color
+var (
+	diffHeaderColor  = forcedColor(color.Bold)
+	diffHunkColor    = forcedColor(color.FgCyan)
+	diffRemovedColor = forcedColor(color.FgRed)
+	diffAddedColor   = forcedColor(color.FgGreen)
+	// Changed words within changed lines
+	diffRemovedWordColor = forcedColor(color.FgRed, color.ReverseVideo)
+	diffAddedWordColor   = forcedColor(color.FgGreen, color.ReverseVideo)
+)
+
+// forcedColor returns a color that is applied even when output is not a terminal
+func forcedColor(attrs ...color.Attribute) *color.Color {
+	c := color.New(attrs...)
+	c.EnableColor()
+	return c
+}
+
+// DiffOptions controls how FormatDiff shows a diff
+type DiffOptions struct {
+	// OldName and NewName are shown in the "---" and "+++" header, which is left out
+	// when both are empty
+	OldName string
+	NewName string
+	// Context is the number of unchanged lines shown around each change; 0 selects 3
+	Context int
+	// Patience anchors the diff on lines occurring once in both texts, so changes to
+	// code stay grouped instead of matching stray braces and blank lines
+	Patience bool
+	// Color adds ANSI colors, highlighting the changed words within changed lines
+	Color bool
+}
+
+// FormatDiff returns the changes from old to new as unified diff hunks, or "" when
+// they are equal
+func FormatDiff(old, new string, opts DiffOptions) string {
+	if old == new {
+		return ""
+	}
+	context := opts.Context
+	if context <= 0 {
+		context = defaultDiffContext
+	}
+
+	ops := textdiff.Lines(textdiff.SplitLines(old), textdiff.SplitLines(new), opts.Patience)
+
+	var b strings.Builder
+	if opts.OldName != "" || opts.NewName != "" {
+		fmt.Fprintf(&b, "--- %s\n+++ %s\n", opts.OldName, opts.NewName)
+	}
+	for _, h := range hunks(ops, context) {
+		b.WriteString(h.header())
+		for _, op := range ops[h.start:h.end] {
+			b.WriteByte(op.Kind)
+			b.WriteString(op.Line)
+			if !strings.HasSuffix(op.Line, "\n") {
+				b.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+
+	if opts.Color {
+		return colorizeDiff(b.String())
+	}
+	return b.String()
+}
+
+// UnifiedDiff returns the changes from old to new as a unified diff with the given
+// file names in its header, or "" when they are equal
+func UnifiedDiff(oldName, newName, old, new string) string {
+	return FormatDiff(old, new, DiffOptions{OldName: oldName, NewName: newName})
+}
+
+// PrintDiff prints the changes from old to new as unified diff hunks
+func PrintDiff(old, new string) {
+	PrintColoredDiff(FormatDiff(old, new, DiffOptions{}))
+}
+
+// PrintUnifiedDiff prints the unified diff from old to new with the given file names
+func PrintUnifiedDiff(oldName, newName, old, new string) {
+	PrintColoredDiff(UnifiedDiff(oldName, newName, old, new))
+}
+
+// PrintColoredDiff prints a diff returned by FormatDiff or UnifiedDiff, colored
+// unless colors are disabled, for instance with NO_COLOR or when output is not a terminal
+func PrintColoredDiff(diff string) {
+	if color.NoColor {
+		fmt.Print(diff)
+		return
+	}
+	fmt.Print(colorizeDiff(diff))
+}
+
+// DiffStats returns the number of lines added and removed going from old to new
+func DiffStats(old, new string) (added, removed int) {
+	if old == new {
+		return 0, 0
+	}
+	for _, op := range textdiff.Lines(textdiff.SplitLines(old), textdiff.SplitLines(new), false) {
+		switch op.Kind {
+		case textdiff.Add:
+			added++
+		case textdiff.Remove:
+			removed++
+		}
+	}
+	return added, removed
+}
+
+// hunk is a range of operations shown together, with the line numbers it starts at
+type hunk struct {
+	start, end         int
+	oldStart, newStart int
+	oldCount, newCount int
+}
+
+// header returns the hunk's "@@ -l,s +l,s @@" line
+func (h hunk) header() string {
+	span := func(start, count int) string {
+		if count == 0 {
+			// An empty range is numbered after the line it follows
+			start--
+		}
+		if count == 1 {
+			return fmt.Sprint(start)
+		}
+		return fmt.Sprintf("%d,%d", start, count)
+	}
+	return fmt.Sprintf("@@ -%s +%s @@\n", span(h.oldStart, h.oldCount), span(h.newStart, h.newCount))
+}
+
+// hunks groups the changes in ops with context lines around them, merging groups
+// whose context would overlap or touch
+func hunks(ops []textdiff.Op, context int) []hunk {
+	var result []hunk
+	oldLine, newLine := 1, 1
+	var current *hunk
+	lastChange := -1
+
+	for i, op := range ops {
+		if op.Kind != textdiff.Keep {
+			// Unchanged lines between two changes are all shown when at most 2*context
+			if current == nil || i-lastChange-1 > 2*context {
+				if current != nil {
+					result = append(result, closeHunk(*current, ops, lastChange, context))
+				}
+				start := max(i-context, 0)
+				current = &hunk{start: start, oldStart: oldLine - (i - start), newStart: newLine - (i - start)}
+			}
+			lastChange = i
+		}
+
+		switch op.Kind {
+		case textdiff.Keep:
+			oldLine++
+			newLine++
+		case textdiff.Remove:
+			oldLine++
+		case textdiff.Add:
+			newLine++
+		}
+	}
+	if current != nil {
+		result = append(result, closeHunk(*current, ops, lastChange, context))
+	}
+	return result
+}
+
+// closeHunk ends h with the context following its last change and counts its lines
+func closeHunk(h hunk, ops []textdiff.Op, lastChange, context int) hunk {
+	h.end = min(lastChange+context+1, len(ops))
+	for _, op := range ops[h.start:h.end] {
+		if op.Kind != textdiff.Add {
+			h.oldCount++
+		}
+		if op.Kind != textdiff.Remove {
+			h.newCount++
+		}
+	}
+	return h
+}
+
+// colorizeDiff colors a unified diff. Removed lines directly followed by added lines
+// are paired in order, and the words that differ between a pair are highlighted.
+func colorizeDiff(diff string) string {
+	lines := textdiff.SplitLines(diff)
+	colored := make([]string, len(lines))
+
+	inHunk := false
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		switch {
+		case strings.HasPrefix(line, "@@"):
+			inHunk = true
+			colored[i] = colorLine(diffHunkColor, line)
+		case !inHunk:
+			colored[i] = colorLine(diffHeaderColor, line)
+		case strings.HasPrefix(line, "-"):
+			removed, next := changedRun(lines, i, '-')
+			added, end := changedRun(lines, next, '+')
+			for k, idx := range removed {
+				if k < len(added) {
+					colored[idx], colored[added[k]] = highlightWords(lines[idx], lines[added[k]])
+				} else {
+					colored[idx] = colorLine(diffRemovedColor, lines[idx])
+				}
+			}
+			for _, idx := range added[min(len(removed), len(added)):] {
+				colored[idx] = colorLine(diffAddedColor, lines[idx])
+			}
+			i = end - 1
+		case strings.HasPrefix(line, "+"):
+			colored[i] = colorLine(diffAddedColor, line)
+		}
+	}
+
+	var b strings.Builder
+	for i, line := range lines {
+		if colored[i] != "" {
+			b.WriteString(colored[i])
+		} else {
+			b.WriteString(line)
+		}
+	}
+	return b.String()
+}
+
+// changedRun returns the indexes of the lines starting with kind from start on,
+// skipping "\ No newline at end of file" markers, and the index after the run
+func changedRun(lines []string, start int, kind byte) ([]int, int) {
+	var run []int
+	i := start
+	for ; i < len(lines); i++ {
+		switch {
+		case lines[i] != "" && lines[i][0] == kind:
+			run = append(run, i)
+		case strings.HasPrefix(lines[i], "\\") && len(run) > 0:
+		default:
+			return run, i
+		}
+	}
+	return run, i
+}
+
+// colorLine colors a diff line, keeping its newline outside the escape codes
+func colorLine(c *color.Color, line string) string {
+	text := strings.TrimSuffix(line, "\n")
+	return c.Sprint(text) + line[len(text):]
+}
+
+// highlightWords colors a removed and an added line, highlighting the words that
+// differ between them. Lines with nothing but whitespace in common are colored whole.
+func highlightWords(removed, added string) (string, string) {
+	oldWords := splitWords(strings.TrimSuffix(removed[1:], "\n"))
+	newWords := splitWords(strings.TrimSuffix(added[1:], "\n"))
+	ops := textdiff.Lines(oldWords, newWords, false)
+
+	shared := false
+	for _, op := range ops {
+		if op.Kind == textdiff.Keep && strings.TrimSpace(op.Line) != "" {
+			shared = true
+			break
+		}
+	}
+	if !shared {
+		return colorLine(diffRemovedColor, removed), colorLine(diffAddedColor, added)
+	}
+
+	var oldLine, newLine strings.Builder
+	oldLine.WriteString(diffRemovedColor.Sprint("-"))
+	newLine.WriteString(diffAddedColor.Sprint("+"))
+	for i := 0; i < len(ops); {
+		// Color runs of the same kind together
+		j := i
+		var text strings.Builder
+		for ; j < len(ops) && ops[j].Kind == ops[i].Kind; j++ {
+			text.WriteString(ops[j].Line)
+		}
+		switch ops[i].Kind {
+		case textdiff.Keep:
+			oldLine.WriteString(diffRemovedColor.Sprint(text.String()))
+			newLine.WriteString(diffAddedColor.Sprint(text.String()))
+		case textdiff.Remove:
+			oldLine.WriteString(diffRemovedWordColor.Sprint(text.String()))
+		case textdiff.Add:
+			newLine.WriteString(diffAddedWordColor.Sprint(text.String()))
+		}
+		i = j
+	}
+
+	return oldLine.String() + removed[len(strings.TrimSuffix(removed, "\n")):],
+		newLine.String() + added[len(strings.TrimSuffix(added, "\n")):]
+}
+
+// splitWords splits a line into words, runs of whitespace and single punctuation characters
+func splitWords(line string) []string {
+	var words []string
+	runes := []rune(line)
+	for i := 0; i < len(runes); {
+		j := i + 1
+		switch {
+		case isWordRune(runes[i]):
+			for j < len(runes) && isWordRune(runes[j]) {
+				j++
+			}
+		case unicode.IsSpace(runes[i]):
+			for j < len(runes) && unicode.IsSpace(runes[j]) {
+				j++
+			}
+		}
+		words = append(words, string(runes[i:j]))
+		i = j
+	}
+	return words
+}
+
+// isWordRune reports whether r belongs to a word when highlighting changed words
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
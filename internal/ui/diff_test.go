@@ -0,0 +1,145 @@
+package ui
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatDiff(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		opts     DiffOptions
+		want     string
+	}{
+		{
+			name: "equal",
+			old:  "a\n",
+			new:  "a\n",
+			want: "",
+		},
+		{
+			name: "empty old",
+			new:  "a\nb\n",
+			opts: DiffOptions{OldName: "/dev/null", NewName: "out.txt"},
+			want: "--- /dev/null\n+++ out.txt\n@@ -0,0 +1,2 @@\n+a\n+b\n",
+		},
+		{
+			name: "empty new",
+			old:  "a\nb\n",
+			want: "@@ -1,2 +0,0 @@\n-a\n-b\n",
+		},
+		{
+			name: "single line",
+			old:  "a\n",
+			new:  "b\n",
+			want: "@@ -1 +1 @@\n-a\n+b\n",
+		},
+		{
+			name: "missing trailing newline added",
+			old:  "a\nb",
+			new:  "a\nb\n",
+			want: "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+b\n",
+		},
+		{
+			name: "missing trailing newline removed",
+			old:  "a\n",
+			new:  "a",
+			want: "@@ -1 +1 @@\n-a\n+a\n\\ No newline at end of file\n",
+		},
+		{
+			name: "insert with context",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			new:  "1\n2\n3\n4\n5\nx\n6\n7\n8\n9\n10\n",
+			want: "@@ -3,6 +3,7 @@\n 3\n 4\n 5\n+x\n 6\n 7\n 8\n",
+		},
+		{
+			name: "insert at start with no old lines before it",
+			old:  "a\nb\n",
+			new:  "x\na\nb\n",
+			opts: DiffOptions{Context: 1},
+			want: "@@ -1 +1,2 @@\n+x\n a\n",
+		},
+		{
+			name: "separate hunks",
+			old:  "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			new:  "x\n2\n3\n4\n5\n6\n7\n8\n9\ny\n",
+			opts: DiffOptions{Context: 1},
+			want: "@@ -1,2 +1,2 @@\n-1\n+x\n 2\n@@ -9,2 +9,2 @@\n 9\n-10\n+y\n",
+		},
+		{
+			name: "hunks apart by one line more than their context",
+			old:  "1\n2\n3\n4\n5\n",
+			new:  "x\n2\n3\n4\ny\n",
+			opts: DiffOptions{Context: 1},
+			want: "@@ -1,2 +1,2 @@\n-1\n+x\n 2\n@@ -4,2 +4,2 @@\n 4\n-5\n+y\n",
+		},
+		{
+			name: "hunks whose context touches",
+			old:  "1\n2\n3\n4\n",
+			new:  "x\n2\n3\ny\n",
+			opts: DiffOptions{Context: 1},
+			want: "@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n-4\n+y\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FormatDiff(tt.old, tt.new, tt.opts); got != tt.want {
+				t.Errorf("FormatDiff(%q, %q) =\n%s\nwant\n%s", tt.old, tt.new, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHunkHeader(t *testing.T) {
+	tests := []struct {
+		h    hunk
+		want string
+	}{
+		{hunk{oldStart: 1, newStart: 1, oldCount: 0, newCount: 2}, "@@ -0,0 +1,2 @@\n"},
+		{hunk{oldStart: 4, newStart: 4, oldCount: 0, newCount: 1}, "@@ -3,0 +4 @@\n"},
+		{hunk{oldStart: 4, newStart: 4, oldCount: 2, newCount: 0}, "@@ -4,2 +3,0 @@\n"},
+		{hunk{oldStart: 7, newStart: 8, oldCount: 1, newCount: 1}, "@@ -7 +8 @@\n"},
+		{hunk{oldStart: 7, newStart: 8, oldCount: 3, newCount: 4}, "@@ -7,3 +8,4 @@\n"},
+	}
+	for _, tt := range tests {
+		if got := tt.h.header(); got != tt.want {
+			t.Errorf("header of %+v = %q, want %q", tt.h, got, tt.want)
+		}
+	}
+}
+
+func TestDiffStats(t *testing.T) {
+	tests := []struct {
+		old, new       string
+		added, removed int
+	}{
+		{"", "", 0, 0},
+		{"", "a\nb\n", 2, 0},
+		{"a\nb\n", "", 0, 2},
+		{"a\nb\nc\n", "a\nx\nc\n", 1, 1},
+		{"a", "a\n", 1, 1},
+	}
+	for _, tt := range tests {
+		added, removed := DiffStats(tt.old, tt.new)
+		if added != tt.added || removed != tt.removed {
+			t.Errorf("DiffStats(%q, %q) = %d, %d, want %d, %d", tt.old, tt.new, added, removed, tt.added, tt.removed)
+		}
+	}
+}
+
+func TestFormatDiffColorKeepsText(t *testing.T) {
+	old, new := "a\nfoo bar\nc\n", "a\nfoo baz\nc\n"
+	plain := FormatDiff(old, new, DiffOptions{})
+	colored := FormatDiff(old, new, DiffOptions{Color: true})
+
+	stripped := colored
+	for strings.Contains(stripped, "\x1b[") {
+		start := strings.Index(stripped, "\x1b[")
+		end := strings.IndexByte(stripped[start:], 'm')
+		stripped = stripped[:start] + stripped[start+end+1:]
+	}
+	if stripped != plain {
+		t.Errorf("colored diff without escape codes =\n%s\nwant\n%s", stripped, plain)
+	}
+}
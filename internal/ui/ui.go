@@ -1,8 +1,10 @@
 package ui
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/fatih/color"
@@ -156,27 +158,6 @@ func PrintTable(headers []string, rows [][]string) {
 	}
 }
 
-// PrintDiff prints a diff between two strings
-func PrintDiff(old, new string) {
-	// Split into lines
-	oldLines := strings.Split(old, "\n")
-	newLines := strings.Split(new, "\n")
-
-	// Print diff
-	for i := 0; i < len(oldLines) || i < len(newLines); i++ {
-		if i >= len(oldLines) {
-			SuccessColor.Printf("+ %s\n", newLines[i])
-		} else if i >= len(newLines) {
-			ErrorColor.Printf("- %s\n", oldLines[i])
-		} else if oldLines[i] != newLines[i] {
-			ErrorColor.Printf("- %s\n", oldLines[i])
-			SuccessColor.Printf("+ %s\n", newLines[i])
-		} else {
-			fmt.Printf("  %s\n", oldLines[i])
-		}
-	}
-}
-
 // PrintSpinner prints a loading spinner
 func PrintSpinner(message string, done chan bool) {
 	spinner := []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
@@ -210,3 +191,50 @@ func PrintBox(message string) {
 	}
 	fmt.Println("└" + strings.Repeat("─", maxLen+2) + "┘")
 }
+
+// PrefixWriter prints each line written to it behind a colored prefix, for
+// streaming the output of commands such as hooks
+type PrefixWriter struct {
+	prefix string
+	color  *color.Color
+	mu     sync.Mutex
+	buf    []byte
+}
+
+// NewPrefixWriter creates a writer printing lines behind prefix in the given color
+func NewPrefixWriter(prefix string, c *color.Color) *PrefixWriter {
+	return &PrefixWriter{prefix: prefix, color: c}
+}
+
+// Write prints every complete line and keeps a trailing partial line until the next write or Flush
+func (p *PrefixWriter) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.printLine(string(p.buf[:i]))
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush prints a trailing partial line
+func (p *PrefixWriter) Flush() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.buf) > 0 {
+		p.printLine(string(p.buf))
+		p.buf = nil
+	}
+}
+
+// printLine prints a line behind the prefix; callers must hold p.mu
+func (p *PrefixWriter) printLine(line string) {
+	fmt.Printf("%s %s\n", p.color.Sprint(p.prefix), strings.TrimRight(line, "\r"))
+}
@@ -0,0 +1,92 @@
+package watch
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Backend selects how file changes are detected
+type Backend string
+
+const (
+	// BackendAuto uses fsnotify, falling back to polling when a watched directory is on a
+	// network or shared filesystem, or when fsnotify cannot be started
+	BackendAuto Backend = "auto"
+	// BackendNotify uses the operating system's file notifications through fsnotify
+	BackendNotify Backend = "fsnotify"
+	// BackendPoll scans the watched directories every watch interval
+	BackendPoll Backend = "poll"
+)
+
+// ParseBackend parses a backend name; an empty name selects BackendAuto
+func ParseBackend(name string) (Backend, error) {
+	switch Backend(name) {
+	case "", BackendAuto:
+		return BackendAuto, nil
+	case BackendNotify, BackendPoll:
+		return Backend(name), nil
+	}
+	return "", fmt.Errorf("unknown watch backend %q: must be auto, fsnotify or poll", name)
+}
+
+// backend delivers fsnotify events for the files directly inside watched directories.
+// Every backend feeds the same event pipeline.
+type backend interface {
+	Add(dir string) error
+	Events() <-chan fsnotify.Event
+	Errors() <-chan error
+	Close() error
+}
+
+// notifyBackend adapts an fsnotify watcher to the backend interface
+type notifyBackend struct {
+	watcher *fsnotify.Watcher
+}
+
+// Add watches dir
+func (b notifyBackend) Add(dir string) error {
+	return b.watcher.Add(dir)
+}
+
+// Events returns the fsnotify event channel
+func (b notifyBackend) Events() <-chan fsnotify.Event {
+	return b.watcher.Events
+}
+
+// Errors returns the fsnotify error channel
+func (b notifyBackend) Errors() <-chan error {
+	return b.watcher.Errors
+}
+
+// Close stops the fsnotify watcher
+func (b notifyBackend) Close() error {
+	return b.watcher.Close()
+}
+
+// openBackend starts the selected backend for dirs and reports which one was used
+func openBackend(requested Backend, dirs []string, interval time.Duration) (backend, Backend, error) {
+	kind := requested
+	if kind == BackendAuto {
+		kind = BackendNotify
+		for _, dir := range dirs {
+			if isNetworkFS(dir) {
+				kind = BackendPoll
+				break
+			}
+		}
+	}
+
+	if kind == BackendNotify {
+		w, err := fsnotify.NewWatcher()
+		if err == nil {
+			return notifyBackend{w}, BackendNotify, nil
+		}
+		if requested != BackendAuto {
+			return nil, "", fmt.Errorf("error creating watcher: %w", err)
+		}
+	}
+
+	return newPoller(interval), BackendPoll, nil
+}
@@ -0,0 +1,189 @@
+package watch
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// EventType identifies what happened in a watcher
+type EventType string
+
+const (
+	// EventStarted is published once the watcher has started watching
+	EventStarted EventType = "started"
+	// EventChangeDetected is published for each file change affecting the watched jobs
+	EventChangeDetected EventType = "change_detected"
+	// EventRenderStarted is published before a job is regenerated
+	EventRenderStarted EventType = "render_started"
+	// EventRenderSucceeded is published after a job's outputs were written
+	EventRenderSucceeded EventType = "render_succeeded"
+	// EventRenderFailed is published when a job could not be regenerated
+	EventRenderFailed EventType = "render_failed"
+	// EventError is published for problems outside rendering, such as failing
+	// post_write hooks, orphaned outputs and file system watcher errors
+	EventError EventType = "error"
+	// EventStopped is the last event published, once the watcher has stopped
+	EventStopped EventType = "stopped"
+)
+
+// Event is something that happened in a watcher
+type Event struct {
+	Type EventType
+	Time time.Time
+	// Target and Template identify the job for render events
+	Target   string
+	Template string
+	// Files are the changed files for change and render started events,
+	// and the written outputs for render succeeded events
+	Files []string
+	// Err is set for render failed and error events
+	Err error
+}
+
+// legacyQueueSize bounds the events buffered for StatusChannel and ErrorChannel, which
+// callers often never read
+const legacyQueueSize = 16
+
+// subscriber buffers the events of one subscription, so publishing never blocks.
+// Without a limit no event is dropped however slowly the subscriber reads; with one,
+// the oldest events are dropped once limit events are waiting.
+type subscriber struct {
+	mu     sync.Mutex
+	queue  []Event
+	limit  int
+	closed bool
+	notify chan struct{}
+	out    chan Event
+}
+
+// push queues an event for the subscriber
+func (s *subscriber) push(event Event) {
+	s.mu.Lock()
+	if !s.closed {
+		if s.limit > 0 && len(s.queue) >= s.limit {
+			s.queue = append(s.queue[:0], s.queue[len(s.queue)-s.limit+1:]...)
+		}
+		s.queue = append(s.queue, event)
+	}
+	s.mu.Unlock()
+
+	s.wake()
+}
+
+// close lets the subscriber finish once its queued events are delivered
+func (s *subscriber) close() {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+
+	s.wake()
+}
+
+// wake signals forward that the queue or closed state changed
+func (s *subscriber) wake() {
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// forward delivers queued events in order until the subscriber is closed and
+// drained or ctx is done, then closes the subscription channel
+func (s *subscriber) forward(ctx context.Context) {
+	defer close(s.out)
+
+	for {
+		s.mu.Lock()
+		queue, closed := s.queue, s.closed
+		s.queue = nil
+		s.mu.Unlock()
+
+		if len(queue) == 0 {
+			if closed {
+				return
+			}
+			select {
+			case <-s.notify:
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		for _, event := range queue {
+			select {
+			case s.out <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// Subscribe returns a channel receiving every event published from now on. Each
+// subscriber has its own unbounded buffer, so a slow subscriber never blocks the
+// watcher or other subscribers. The channel is closed after EventStopped or when
+// ctx is done.
+func (w *Watcher) Subscribe(ctx context.Context) <-chan Event {
+	return w.subscribe(ctx, 0)
+}
+
+// subscribe is Subscribe with at most limit events buffered, or any number for 0
+func (w *Watcher) subscribe(ctx context.Context, limit int) <-chan Event {
+	sub := &subscriber{
+		limit:  limit,
+		notify: make(chan struct{}, 1),
+		out:    make(chan Event),
+	}
+
+	w.subsMu.Lock()
+	if w.stopped {
+		sub.closed = true
+	} else {
+		w.subs[sub] = struct{}{}
+	}
+	w.subsMu.Unlock()
+
+	go func() {
+		sub.forward(ctx)
+
+		w.subsMu.Lock()
+		delete(w.subs, sub)
+		w.subsMu.Unlock()
+	}()
+
+	return sub.out
+}
+
+// publish sends an event to every subscriber without blocking
+func (w *Watcher) publish(event Event) {
+	event.Time = time.Now()
+
+	w.subsMu.Lock()
+	defer w.subsMu.Unlock()
+
+	for sub := range w.subs {
+		sub.push(event)
+	}
+}
+
+// closeSubscribers publishes EventStopped and lets every subscription finish. The
+// watcher counts as stopped before the event is published, so subscribing in
+// reaction to it returns a closed channel.
+func (w *Watcher) closeSubscribers() {
+	w.subsMu.Lock()
+	defer w.subsMu.Unlock()
+
+	w.stopped = true
+	stopped := Event{Type: EventStopped, Time: time.Now()}
+	for sub := range w.subs {
+		sub.push(stopped)
+		sub.close()
+	}
+}
+
+// publishError publishes an error event
+func (w *Watcher) publishError(err error) {
+	w.publish(Event{Type: EventError, Err: err})
+}
@@ -0,0 +1,27 @@
+//go:build linux
+
+package watch
+
+import "syscall"
+
+// networkFSTypes are the filesystem magic numbers fsnotify does not receive changes from
+// reliably: NFS, SMB, 9P as used by WSL and container shares, and FUSE and virtiofs as
+// used by Docker Desktop bind mounts
+var networkFSTypes = map[int64]bool{
+	0x6969:     true, // NFS
+	0xff534d42: true, // CIFS
+	0xfe534d42: true, // SMB2
+	0x517b:     true, // SMB
+	0x01021997: true, // 9P
+	0x65735546: true, // FUSE
+	0x6a656a63: true, // virtiofs
+}
+
+// isNetworkFS reports whether dir is on a filesystem that needs polling
+func isNetworkFS(dir string) bool {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(dir, &stat); err != nil {
+		return false
+	}
+	return networkFSTypes[int64(stat.Type)]
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package watch
+
+// isNetworkFS reports whether dir is on a filesystem that needs polling; filesystem
+// types are only detected on Linux, so elsewhere the poll backend must be selected explicitly
+func isNetworkFS(dir string) bool {
+	return false
+}
@@ -0,0 +1,280 @@
+package watch
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/singoesdeep/templater/internal/engine"
+	"github.com/singoesdeep/templater/internal/project"
+)
+
+// NodeKind is the role of a file in the dependency graph
+type NodeKind string
+
+const (
+	// NodeTemplate is a template rendered by a job
+	NodeTemplate NodeKind = "template"
+	// NodePartial is a template only used by other templates
+	NodePartial NodeKind = "partial"
+	// NodeData is a data file
+	NodeData NodeKind = "data"
+	// NodeOutput is an output file, or the output directory of a template with an output directive
+	NodeOutput NodeKind = "output"
+)
+
+// EdgeKind is the reason one file feeds into another
+type EdgeKind string
+
+const (
+	// EdgeDependsOn links a file listed in depends_on metadata to the template listing it
+	EdgeDependsOn EdgeKind = "depends_on"
+	// EdgeTemplate links the file defining a named template to a template invoking it
+	EdgeTemplate EdgeKind = "template"
+	// EdgeData links a data file to the template it is bound to
+	EdgeData EdgeKind = "data"
+	// EdgeOutput links a template to its output
+	EdgeOutput EdgeKind = "output"
+)
+
+// Node is a file in the dependency graph
+type Node struct {
+	Path string   `json:"path"`
+	Kind NodeKind `json:"kind"`
+}
+
+// Edge means a change to From affects To
+type Edge struct {
+	From string   `json:"from"`
+	To   string   `json:"to"`
+	Kind EdgeKind `json:"kind"`
+}
+
+// Graph is the dependency graph of a set of jobs. Edges point from a file to the
+// files affected by changing it. A graph is not modified once built.
+type Graph struct {
+	nodes map[string]NodeKind
+	edges map[string]map[string]EdgeKind
+	// reverse holds the edges keyed by their target, for walking upstream
+	reverse map[string]map[string]EdgeKind
+}
+
+// BuildGraph builds the dependency graph of the jobs from depends_on metadata,
+// {{template}} references and data bindings. Templates that cannot be analyzed
+// are left without dependencies and reported in the returned error, alongside the graph.
+func BuildGraph(jobs []project.Job) (*Graph, error) {
+	g := &Graph{
+		nodes:   make(map[string]NodeKind),
+		edges:   make(map[string]map[string]EdgeKind),
+		reverse: make(map[string]map[string]EdgeKind),
+	}
+
+	for _, job := range jobs {
+		g.addNode(job.Template, NodeTemplate)
+		for _, data := range job.Data {
+			g.addNode(data, NodeData)
+			g.addEdge(data, job.Template, EdgeData)
+		}
+		g.addNode(job.Output, NodeOutput)
+		g.addEdge(job.Template, job.Output, EdgeOutput)
+	}
+
+	// Analyze every template and the partials they pull in
+	var errs []error
+	analyzed := make(map[string]*engine.TemplateReferences)
+	var analyze func(path string)
+	analyze = func(path string) {
+		path = filepath.Clean(path)
+		if _, done := analyzed[path]; done {
+			return
+		}
+		refs, err := engine.AnalyzeTemplate(path)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			refs = &engine.TemplateReferences{}
+		}
+		analyzed[path] = refs
+
+		for _, dep := range refs.DependsOn {
+			g.addNode(dep, NodePartial)
+			g.addEdge(dep, path, EdgeDependsOn)
+			analyze(dep)
+		}
+	}
+	for _, job := range jobs {
+		analyze(job.Template)
+	}
+
+	// Link each {{template}} invocation to the file defining the name, looking in
+	// the files parsed together with the template, as the renderer does
+	for path, refs := range analyzed {
+		available := g.upstream(path, EdgeDependsOn)
+		for _, name := range refs.Uses {
+			for _, source := range available {
+				if source != path && slices.Contains(analyzed[source].Defines, name) {
+					g.addEdge(source, path, EdgeTemplate)
+				}
+			}
+		}
+	}
+
+	return g, errors.Join(errs...)
+}
+
+// addNode adds a node; a file used both as a job template and as a partial stays a template
+func (g *Graph) addNode(path string, kind NodeKind) {
+	path = filepath.Clean(path)
+	if existing, exists := g.nodes[path]; exists && (existing == NodeTemplate || kind != NodeTemplate) {
+		return
+	}
+	g.nodes[path] = kind
+}
+
+// addEdge adds an edge unless the two files are already linked
+func (g *Graph) addEdge(from, to string, kind EdgeKind) {
+	from, to = filepath.Clean(from), filepath.Clean(to)
+	if g.edges[from] == nil {
+		g.edges[from] = make(map[string]EdgeKind)
+	}
+	if g.reverse[to] == nil {
+		g.reverse[to] = make(map[string]EdgeKind)
+	}
+	if _, exists := g.edges[from][to]; !exists {
+		g.edges[from][to] = kind
+		g.reverse[to][from] = kind
+	}
+}
+
+// upstream returns path and every file reaching it through edges of the given kinds
+func (g *Graph) upstream(path string, kinds ...EdgeKind) []string {
+	seen := map[string]bool{path: true}
+	queue := []string{path}
+	for i := 0; i < len(queue); i++ {
+		for from, k := range g.reverse[queue[i]] {
+			if !seen[from] && slices.Contains(kinds, k) {
+				seen[from] = true
+				queue = append(queue, from)
+			}
+		}
+	}
+	sort.Strings(queue)
+	return queue
+}
+
+// Dependencies returns the partials a template is rendered with, directly or transitively
+func (g *Graph) Dependencies(templatePath string) []string {
+	templatePath = filepath.Clean(templatePath)
+	var deps []string
+	for _, path := range g.upstream(templatePath, EdgeDependsOn, EdgeTemplate) {
+		if path != templatePath {
+			deps = append(deps, path)
+		}
+	}
+	return deps
+}
+
+// IsTemplate reports whether path is a job template or a partial in the graph
+func (g *Graph) IsTemplate(path string) bool {
+	kind := g.nodes[filepath.Clean(path)]
+	return kind == NodeTemplate || kind == NodePartial
+}
+
+// Affected returns every file affected by a change to path, directly or transitively
+func (g *Graph) Affected(path string) []string {
+	path = filepath.Clean(path)
+	seen := map[string]bool{path: true}
+	queue := []string{path}
+	var affected []string
+	for i := 0; i < len(queue); i++ {
+		for to := range g.edges[queue[i]] {
+			if !seen[to] {
+				seen[to] = true
+				queue = append(queue, to)
+				affected = append(affected, to)
+			}
+		}
+	}
+	sort.Strings(affected)
+	return affected
+}
+
+// Partials returns the files that are only used by other templates
+func (g *Graph) Partials() []string {
+	var partials []string
+	for path, kind := range g.nodes {
+		if kind == NodePartial {
+			partials = append(partials, path)
+		}
+	}
+	sort.Strings(partials)
+	return partials
+}
+
+// Nodes returns the graph's nodes sorted by path
+func (g *Graph) Nodes() []Node {
+	nodes := make([]Node, 0, len(g.nodes))
+	for path, kind := range g.nodes {
+		nodes = append(nodes, Node{Path: path, Kind: kind})
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].Path < nodes[j].Path })
+	return nodes
+}
+
+// Edges returns the graph's edges sorted by source and target
+func (g *Graph) Edges() []Edge {
+	var edges []Edge
+	for from, targets := range g.edges {
+		for to, kind := range targets {
+			edges = append(edges, Edge{From: from, To: to, Kind: kind})
+		}
+	}
+	sort.Slice(edges, func(i, j int) bool {
+		if edges[i].From != edges[j].From {
+			return edges[i].From < edges[j].From
+		}
+		return edges[i].To < edges[j].To
+	})
+	return edges
+}
+
+// MarshalJSON encodes the graph as its sorted nodes and edges
+func (g *Graph) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Nodes []Node `json:"nodes"`
+		Edges []Edge `json:"edges"`
+	}{
+		Nodes: g.Nodes(),
+		Edges: g.Edges(),
+	})
+}
+
+// WriteDOT writes the graph in Graphviz DOT format
+func (g *Graph) WriteDOT(w io.Writer) error {
+	shapes := map[NodeKind]string{
+		NodeTemplate: "box",
+		NodePartial:  "component",
+		NodeData:     "note",
+		NodeOutput:   "folder",
+	}
+
+	var b strings.Builder
+	b.WriteString("digraph templater {\n")
+	b.WriteString("  rankdir=LR;\n")
+	for _, node := range g.Nodes() {
+		fmt.Fprintf(&b, "  %q [shape=%s];\n", node.Path, shapes[node.Kind])
+	}
+	for _, edge := range g.Edges() {
+		fmt.Fprintf(&b, "  %q -> %q [label=%q];\n", edge.From, edge.To, edge.Kind)
+	}
+	b.WriteString("}\n")
+
+	if _, err := io.WriteString(w, b.String()); err != nil {
+		return fmt.Errorf("error writing graph: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+package watch
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// fileState is what the poller remembers about a file between scans
+type fileState struct {
+	modTime time.Time
+	size    int64
+	isDir   bool
+	hash    [sha256.Size]byte
+}
+
+// poller is a backend that scans the watched directories every interval and reports
+// differences as fsnotify events. Files whose modification time or size changed, or
+// whose modification time is too recent to trust on filesystems with coarse timestamps,
+// are hashed so that only real content changes are reported as writes.
+type poller struct {
+	interval time.Duration
+	mu       sync.Mutex
+	dirs     map[string]map[string]fileState
+	events   chan fsnotify.Event
+	errors   chan error
+	done     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// newPoller starts a poller scanning every interval
+func newPoller(interval time.Duration) *poller {
+	p := &poller{
+		interval: interval,
+		dirs:     make(map[string]map[string]fileState),
+		events:   make(chan fsnotify.Event),
+		errors:   make(chan error),
+		done:     make(chan struct{}),
+	}
+
+	p.wg.Add(1)
+	go p.run()
+	return p
+}
+
+// Add starts polling dir, taking its current contents as the baseline
+func (p *poller) Add(dir string) error {
+	dir = filepath.Clean(dir)
+
+	p.mu.Lock()
+	_, exists := p.dirs[dir]
+	p.mu.Unlock()
+	if exists {
+		return nil
+	}
+
+	states, err := scanDir(dir, nil)
+	if err != nil {
+		return fmt.Errorf("error scanning directory %s: %w", dir, err)
+	}
+
+	p.mu.Lock()
+	if _, exists := p.dirs[dir]; !exists {
+		p.dirs[dir] = states
+	}
+	p.mu.Unlock()
+	return nil
+}
+
+// Events returns the channel of detected changes
+func (p *poller) Events() <-chan fsnotify.Event {
+	return p.events
+}
+
+// Errors returns the channel of scan errors
+func (p *poller) Errors() <-chan error {
+	return p.errors
+}
+
+// Close stops polling and closes the event and error channels
+func (p *poller) Close() error {
+	p.stopOnce.Do(func() {
+		close(p.done)
+		p.wg.Wait()
+		close(p.events)
+		close(p.errors)
+	})
+	return nil
+}
+
+// run scans the watched directories every interval until Close
+func (p *poller) run() {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			p.scan()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// scan compares every watched directory with its last scan and sends the differences
+func (p *poller) scan() {
+	p.mu.Lock()
+	dirs := make(map[string]map[string]fileState, len(p.dirs))
+	for dir, states := range p.dirs {
+		dirs[dir] = states
+	}
+	p.mu.Unlock()
+
+	for dir, previous := range dirs {
+		current, err := scanDir(dir, previous)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Like fsnotify, a removed directory is reported and no longer watched
+			p.mu.Lock()
+			delete(p.dirs, dir)
+			p.mu.Unlock()
+			for name := range previous {
+				p.send(fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove})
+			}
+			p.send(fsnotify.Event{Name: dir, Op: fsnotify.Remove})
+			continue
+		}
+		if err != nil {
+			p.sendError(fmt.Errorf("error scanning directory %s: %w", dir, err))
+			continue
+		}
+
+		for name, state := range current {
+			old, existed := previous[name]
+			switch {
+			case !existed:
+				p.send(fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Create})
+			case !state.isDir && state.hash != old.hash:
+				p.send(fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Write})
+			}
+		}
+		for name := range previous {
+			if _, exists := current[name]; !exists {
+				p.send(fsnotify.Event{Name: filepath.Join(dir, name), Op: fsnotify.Remove})
+			}
+		}
+
+		p.mu.Lock()
+		if _, watched := p.dirs[dir]; watched {
+			p.dirs[dir] = current
+		}
+		p.mu.Unlock()
+	}
+}
+
+// send delivers an event unless the poller is closing
+func (p *poller) send(event fsnotify.Event) {
+	select {
+	case p.events <- event:
+	case <-p.done:
+	}
+}
+
+// sendError delivers an error unless the poller is closing
+func (p *poller) sendError(err error) {
+	select {
+	case p.errors <- err:
+	case <-p.done:
+	}
+}
+
+// scanDir records the state of the entries directly inside dir, reusing the hashes
+// in previous for files that certainly did not change
+func scanDir(dir string, previous map[string]fileState) (map[string]fileState, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	// Timestamps this recent may not have changed yet on filesystems with coarse resolution
+	recent := time.Now().Add(-2 * time.Second)
+
+	states := make(map[string]fileState, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			// Removed since the directory was read
+			continue
+		}
+
+		state := fileState{modTime: info.ModTime(), size: info.Size(), isDir: info.IsDir()}
+		if !state.isDir {
+			old, existed := previous[entry.Name()]
+			if existed && old.modTime.Equal(state.modTime) && old.size == state.size && state.modTime.Before(recent) {
+				state.hash = old.hash
+			} else if content, err := os.ReadFile(filepath.Join(dir, entry.Name())); err == nil {
+				state.hash = sha256.Sum256(content)
+			}
+		}
+		states[entry.Name()] = state
+	}
+
+	return states, nil
+}
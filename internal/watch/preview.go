@@ -0,0 +1,293 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/singoesdeep/templater/internal/engine"
+)
+
+// previewEventsPath is the Server-Sent Events endpoint pages listen on for reloads
+const previewEventsPath = "/_templater/events"
+
+// reloadScript is injected into served pages to reload them when outputs change
+const reloadScript = `<script>new EventSource("` + previewEventsPath + `").onmessage = function () { location.reload(); };</script>`
+
+// PreviewServer serves the watcher's rendered outputs from memory and reloads open
+// pages over Server-Sent Events whenever they are regenerated. While a target fails
+// to render, pages show the error instead of their last content, while other assets
+// such as stylesheets keep serving their last good content.
+type PreviewServer struct {
+	root    string
+	server  *http.Server
+	mu      sync.RWMutex
+	pages   map[string]previewPage
+	errors  map[string]error
+	clients map[chan struct{}]struct{}
+}
+
+// previewPage is a stored output and the source that rendered it
+type previewPage struct {
+	source  string
+	content string
+}
+
+// NewPreviewServer creates a preview server listening on addr. Outputs are served
+// at their path relative to root, or the working directory if root is empty.
+func NewPreviewServer(addr, root string) (*PreviewServer, error) {
+	if root == "" {
+		dir, err := os.Getwd()
+		if err != nil {
+			return nil, fmt.Errorf("error getting working directory: %w", err)
+		}
+		root = dir
+	}
+
+	s := &PreviewServer{
+		root:    root,
+		pages:   make(map[string]previewPage),
+		errors:  make(map[string]error),
+		clients: make(map[chan struct{}]struct{}),
+	}
+	s.server = &http.Server{Addr: addr, Handler: s}
+	return s, nil
+}
+
+// ListenAndServe serves previews until Close is called
+func (s *PreviewServer) ListenAndServe() error {
+	if err := s.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("preview server error: %w", err)
+	}
+	return nil
+}
+
+// Close stops the server and disconnects open pages
+func (s *PreviewServer) Close() error {
+	return s.server.Close()
+}
+
+// Update replaces the outputs rendered from source, clears its error and reloads open pages
+func (s *PreviewServer) Update(source string, outputs []engine.RenderedOutput) {
+	s.mu.Lock()
+	s.removePages(source)
+	for _, output := range outputs {
+		s.pages[s.urlPath(output.Path)] = previewPage{source: source, content: output.Content}
+	}
+	delete(s.errors, source)
+	s.mu.Unlock()
+
+	s.reload()
+}
+
+// Fail records the error rendering source, shown as an overlay on every page, and reloads open pages
+func (s *PreviewServer) Fail(source string, err error) {
+	s.mu.Lock()
+	s.errors[source] = err
+	s.mu.Unlock()
+
+	s.reload()
+}
+
+// Remove drops the outputs and error of a source that is no longer rendered, such as
+// a deleted template, and reloads open pages if it had any
+func (s *PreviewServer) Remove(source string) {
+	s.mu.Lock()
+	_, failed := s.errors[source]
+	delete(s.errors, source)
+	removed := s.removePages(source)
+	s.mu.Unlock()
+
+	if failed || removed {
+		s.reload()
+	}
+}
+
+// removePages drops the outputs rendered from source and reports whether there were
+// any; callers must hold s.mu
+func (s *PreviewServer) removePages(source string) bool {
+	removed := false
+	for urlPath, page := range s.pages {
+		if page.source == source {
+			delete(s.pages, urlPath)
+			removed = true
+		}
+	}
+	return removed
+}
+
+// ServeHTTP serves the reload event stream, the stored outputs and an index of them.
+// While a source fails, pages and the index show the error overlay instead.
+func (s *PreviewServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == previewEventsPath {
+		s.serveEvents(w, r)
+		return
+	}
+
+	s.mu.RLock()
+	overlay := s.errorPage()
+	page, exists := s.pages[r.URL.Path]
+	if !exists && strings.HasSuffix(r.URL.Path, "/") {
+		page, exists = s.pages[r.URL.Path+"index.html"]
+		r.URL.Path += "index.html"
+	}
+	index := s.indexPage()
+	s.mu.RUnlock()
+
+	switch {
+	case overlay != "" && (isPage(r.URL.Path) || !exists && r.URL.Path == "/index.html"):
+		writePage(w, http.StatusInternalServerError, overlay)
+	case exists:
+		s.servePage(w, r.URL.Path, page.content)
+	case r.URL.Path == "/index.html":
+		writePage(w, http.StatusOK, index)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// isPage reports whether an output is served as a page that reloads itself
+func isPage(urlPath string) bool {
+	switch strings.ToLower(path.Ext(urlPath)) {
+	case ".html", ".htm", ".md", ".markdown":
+		return true
+	}
+	return false
+}
+
+// servePage writes an output, turning HTML and markdown into pages that reload themselves
+func (s *PreviewServer) servePage(w http.ResponseWriter, urlPath, content string) {
+	switch strings.ToLower(path.Ext(urlPath)) {
+	case ".html", ".htm":
+		writePage(w, http.StatusOK, injectReload(content))
+	case ".md", ".markdown":
+		writePage(w, http.StatusOK, "<pre>"+html.EscapeString(content)+"</pre>"+reloadScript)
+	default:
+		contentType := mime.TypeByExtension(path.Ext(urlPath))
+		if contentType == "" {
+			contentType = "text/plain; charset=utf-8"
+		}
+		w.Header().Set("Content-Type", contentType)
+		fmt.Fprint(w, content)
+	}
+}
+
+// serveEvents streams a reload event to the page each time outputs change
+func (s *PreviewServer) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	flusher.Flush()
+
+	events := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.clients[events] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, events)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case <-events:
+			fmt.Fprint(w, "data: reload\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// reload notifies every open page; pages with a reload already pending are skipped
+func (s *PreviewServer) reload() {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for client := range s.clients {
+		select {
+		case client <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// urlPath returns the URL an output is served at
+func (s *PreviewServer) urlPath(outputPath string) string {
+	rel, err := filepath.Rel(s.root, outputPath)
+	if err != nil || !filepath.IsLocal(rel) {
+		rel = filepath.Base(outputPath)
+	}
+	return "/" + filepath.ToSlash(rel)
+}
+
+// errorPage returns the overlay listing the current errors, or "" if there are none; callers must hold s.mu
+func (s *PreviewServer) errorPage() string {
+	if len(s.errors) == 0 {
+		return ""
+	}
+
+	sources := make([]string, 0, len(s.errors))
+	for source := range s.errors {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	var b strings.Builder
+	b.WriteString(`<div style="position:fixed;inset:0;overflow:auto;padding:2em;background:#1e1e1e;color:#f48771;font-family:monospace">`)
+	b.WriteString("<h2>Render failed</h2>")
+	for _, source := range sources {
+		fmt.Fprintf(&b, "<h3>%s</h3><pre>%s</pre>", html.EscapeString(source), html.EscapeString(s.errors[source].Error()))
+	}
+	b.WriteString("</div>")
+	b.WriteString(reloadScript)
+	return b.String()
+}
+
+// indexPage returns a page linking every stored output; callers must hold s.mu
+func (s *PreviewServer) indexPage() string {
+	paths := make([]string, 0, len(s.pages))
+	for urlPath := range s.pages {
+		paths = append(paths, urlPath)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	b.WriteString("<h2>Generated outputs</h2><ul>")
+	for _, urlPath := range paths {
+		escaped := html.EscapeString(urlPath)
+		fmt.Fprintf(&b, `<li><a href="%s">%s</a></li>`, escaped, escaped)
+	}
+	b.WriteString("</ul>")
+	b.WriteString(reloadScript)
+	return b.String()
+}
+
+// injectReload inserts the reload script before the closing body tag, or appends it
+func injectReload(page string) string {
+	if i := strings.LastIndex(strings.ToLower(page), "</body>"); i >= 0 {
+		return page[:i] + reloadScript + page[i:]
+	}
+	return page + reloadScript
+}
+
+// writePage writes an HTML page with the given status
+func writePage(w http.ResponseWriter, status int, page string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	fmt.Fprint(w, page)
+}
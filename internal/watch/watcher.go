@@ -1,27 +1,80 @@
 package watch
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"path/filepath"
+	"slices"
 	"sync"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	"github.com/singoesdeep/templater/internal/config"
 	"github.com/singoesdeep/templater/internal/engine"
+	"github.com/singoesdeep/templater/internal/project"
+	"github.com/singoesdeep/templater/internal/reliability"
 )
 
+// OrphanPolicy decides what happens to outputs no longer produced by any template,
+// because their template was deleted or their output directive item was removed
+type OrphanPolicy int
+
+const (
+	// OrphanReport reports orphaned outputs on the error channel and leaves them in place
+	OrphanReport OrphanPolicy = iota
+	// OrphanDelete backs up and deletes orphaned outputs
+	OrphanDelete
+)
+
+// OrphanedOutputError reports an output that is no longer generated
+type OrphanedOutputError struct {
+	Path     string
+	Template string
+}
+
+func (e *OrphanedOutputError) Error() string {
+	return fmt.Sprintf("orphaned output %s: no longer generated from %s", e.Path, e.Template)
+}
+
 // Watcher represents a file system watcher for templates and data files
 type Watcher struct {
-	watcher    *fsnotify.Watcher
-	template   string
-	data       string
-	output     string
+	// backend detects file changes; it is opened by Start using the requested kind
+	backend     backend
+	backendKind Backend
+	active      Backend
+	// expand lists the jobs to keep up to date; it is re-run when files are created or removed
+	expand func() ([]project.Job, error)
+	// extraDirs lists directories to watch besides those of the jobs' inputs
+	extraDirs func() []string
+	// jobs and graph are replaced by the processing goroutine and guarded by mu
+	jobs    []project.Job
+	graph   *Graph
+	outputs map[string][]string
+	orphans OrphanPolicy
+	preview *PreviewServer
+	// work hands coalesced changes from the event loop to the processing goroutine
+	work       chan batch
+	done       chan struct{}
+	lastJob    project.Job
 	interval   time.Duration
 	lastUpdate time.Time
+	watching   bool
 	mu         sync.Mutex
 	stopChan   chan struct{}
+	stopOnce   sync.Once
+	wg         sync.WaitGroup
+
+	// subs are the event subscribers; stopped is set once EventStopped was published
+	subs    map[*subscriber]struct{}
+	stopped bool
+	subsMu  sync.Mutex
+
+	// statusChan and errorChan back StatusChannel and ErrorChannel once requested
 	statusChan chan Status
 	errorChan  chan error
+	statusOnce sync.Once
+	errorOnce  sync.Once
 }
 
 // Status represents the current status of the watcher
@@ -35,47 +88,216 @@ type Status struct {
 
 // NewWatcher creates a new file system watcher
 func NewWatcher(template, data, output string, interval time.Duration) (*Watcher, error) {
-	w, err := fsnotify.NewWatcher()
-	if err != nil {
-		return nil, fmt.Errorf("error creating watcher: %w", err)
+	job := project.Job{Target: template, Template: template, Output: output}
+	if data != "" {
+		job.Data = []string{data}
+	}
+
+	expand := func() ([]project.Job, error) {
+		return []project.Job{job}, nil
+	}
+	return newWatcher(expand, nil, interval)
+}
+
+// NewProjectWatcher creates a watcher over every configured target. Template globs and
+// directories are re-expanded as templates are created and deleted, and each change
+// regenerates only the jobs reading the changed file.
+func NewProjectWatcher(cfg *config.Config, interval time.Duration) (*Watcher, error) {
+	if len(cfg.Targets) == 0 {
+		return nil, fmt.Errorf("no targets to watch")
+	}
+
+	expand := func() ([]project.Job, error) {
+		return project.Jobs(cfg)
+	}
+	extraDirs := func() []string {
+		return project.WatchDirs(cfg)
+	}
+	return newWatcher(expand, extraDirs, interval)
+}
+
+// newWatcher creates a watcher keeping the jobs listed by expand up to date
+func newWatcher(expand func() ([]project.Job, error), extraDirs func() []string, interval time.Duration) (*Watcher, error) {
+	// The interval debounces changes and paces the poll backend's ticker
+	if interval <= 0 {
+		return nil, fmt.Errorf("watch interval must be positive, got %s", interval)
 	}
 
 	return &Watcher{
-		watcher:    w,
-		template:   template,
-		data:       data,
-		output:     output,
-		interval:   interval,
-		stopChan:   make(chan struct{}),
-		statusChan: make(chan Status, 1),
-		errorChan:  make(chan error, 1),
+		backendKind: BackendAuto,
+		expand:      expand,
+		extraDirs:   extraDirs,
+		outputs:     make(map[string][]string),
+		work:        make(chan batch),
+		done:        make(chan struct{}),
+		interval:    interval,
+		stopChan:    make(chan struct{}),
+		subs:        make(map[*subscriber]struct{}),
 	}, nil
 }
 
+// SetOrphanPolicy sets how outputs that are no longer generated are handled; call it before Start
+func (w *Watcher) SetOrphanPolicy(policy OrphanPolicy) {
+	w.orphans = policy
+}
+
+// SetPreview makes the watcher keep preview up to date with every regeneration; call it before Start
+func (w *Watcher) SetPreview(preview *PreviewServer) {
+	w.preview = preview
+}
+
+// SetBackend selects how file changes are detected; call it before Start. The poll
+// backend scans the watched directories every watch interval.
+func (w *Watcher) SetBackend(kind Backend) {
+	w.backendKind = kind
+}
+
+// Backend returns the backend in use, which BackendAuto resolves to once started
+func (w *Watcher) Backend() Backend {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.active
+}
+
 // Start begins watching for file changes
 func (w *Watcher) Start() error {
-	// Add template file to watcher
-	if err := w.watcher.Add(filepath.Dir(w.template)); err != nil {
-		return fmt.Errorf("error watching template directory: %w", err)
+	jobs, err := w.expand()
+	if err != nil {
+		return err
+	}
+	w.jobs = jobs
+	if len(jobs) > 0 {
+		w.lastJob = jobs[0]
+	}
+	w.rebuildGraph()
+
+	b, active, err := openBackend(w.backendKind, w.watchDirs(), w.interval)
+	if err != nil {
+		return err
 	}
+	w.backend = b
 
-	// Add data file to watcher if it exists
-	if w.data != "" {
-		if err := w.watcher.Add(filepath.Dir(w.data)); err != nil {
-			return fmt.Errorf("error watching data directory: %w", err)
+	if err := w.addWatches(); err != nil {
+		b.Close()
+		return err
+	}
+
+	w.mu.Lock()
+	w.active = active
+	w.watching = true
+	w.mu.Unlock()
+
+	templates := make([]string, len(jobs))
+	for i, job := range jobs {
+		templates[i] = job.Template
+	}
+	w.publish(Event{Type: EventStarted, Files: templates})
+
+	// Events are collected in one goroutine and regeneration runs in another,
+	// so a slow render never stops the fsnotify channels from being drained
+	w.wg.Add(2)
+	go func() {
+		defer w.wg.Done()
+		w.watch()
+	}()
+	go func() {
+		defer w.wg.Done()
+		w.process()
+	}()
+
+	return nil
+}
+
+// Run starts the watcher and blocks until ctx is done, then stops it
+func (w *Watcher) Run(ctx context.Context) error {
+	if err := w.Start(); err != nil {
+		return err
+	}
+
+	<-ctx.Done()
+	w.Stop()
+	return nil
+}
+
+// Graph returns the dependency graph of the watched jobs, or nil before Start
+func (w *Watcher) Graph() *Graph {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.graph
+}
+
+// currentJobs returns the jobs being watched
+func (w *Watcher) currentJobs() []project.Job {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.jobs
+}
+
+// rebuildGraph rebuilds the dependency graph of the current jobs
+func (w *Watcher) rebuildGraph() {
+	// Templates that cannot be analyzed report the same error when they are rendered
+	graph, _ := BuildGraph(w.currentJobs())
+
+	w.mu.Lock()
+	w.graph = graph
+	w.mu.Unlock()
+}
+
+// watchDirs returns the existing directories of every job input, partial and extra directory
+func (w *Watcher) watchDirs() []string {
+	var dirs []string
+	for _, job := range w.currentJobs() {
+		for _, input := range job.Inputs() {
+			dirs = append(dirs, filepath.Dir(input))
 		}
 	}
+	for _, partial := range w.Graph().Partials() {
+		dirs = append(dirs, filepath.Dir(partial))
+	}
+	if w.extraDirs != nil {
+		dirs = append(dirs, w.extraDirs()...)
+	}
 
-	// Start watching in a goroutine
-	go w.watch()
+	existing := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			existing = append(existing, filepath.Clean(dir))
+		}
+	}
+	return existing
+}
 
+// addWatches watches the directories returned by watchDirs. Adding a directory already
+// watched is a no-op, so it is called again after changes to pick up directories that
+// were created or replaced, for example by an editor's atomic save.
+func (w *Watcher) addWatches() error {
+	for _, dir := range w.watchDirs() {
+		if err := w.backend.Add(dir); err != nil {
+			return fmt.Errorf("error watching directory %s: %w", dir, err)
+		}
+	}
 	return nil
 }
 
-// Stop stops the watcher
+// Stop stops the watcher, waiting for a regeneration in progress to finish.
+// Subscriptions receive EventStopped and are closed. Calling Stop again has no effect.
 func (w *Watcher) Stop() {
-	close(w.stopChan)
-	w.watcher.Close()
+	w.stopOnce.Do(func() {
+		close(w.stopChan)
+		if w.backend != nil {
+			w.backend.Close()
+		}
+		w.wg.Wait()
+
+		w.mu.Lock()
+		w.watching = false
+		w.mu.Unlock()
+
+		w.closeSubscribers()
+	})
 }
 
 // Status returns the current status of the watcher
@@ -83,52 +305,191 @@ func (w *Watcher) Status() Status {
 	w.mu.Lock()
 	defer w.mu.Unlock()
 
+	return w.status()
+}
+
+// status builds the status for the most recently regenerated job; callers must hold w.mu
+func (w *Watcher) status() Status {
+	var data string
+	if len(w.lastJob.Data) > 0 {
+		data = w.lastJob.Data[0]
+	}
+
 	return Status{
-		TemplatePath: w.template,
-		DataPath:     w.data,
-		OutputPath:   w.output,
+		TemplatePath: w.lastJob.Template,
+		DataPath:     data,
+		OutputPath:   w.lastJob.Output,
 		LastUpdate:   w.lastUpdate,
-		IsWatching:   true,
+		IsWatching:   w.watching,
 	}
 }
 
-// StatusChannel returns the channel for status updates
+// StatusChannel returns a channel receiving the status after each successful regeneration.
+// It is backed by a subscription buffering the latest events, so an unread channel never
+// blocks the watcher and only the oldest updates are dropped. It is closed when the
+// watcher stops, dropping updates not yet received.
 func (w *Watcher) StatusChannel() <-chan Status {
+	w.statusOnce.Do(func() {
+		w.statusChan = make(chan Status)
+		ctx, cancel := context.WithCancel(context.Background())
+		events := w.subscribe(ctx, legacyQueueSize)
+		go func() {
+			// Cancelling ends the subscription and discards its queue
+			defer cancel()
+			defer close(w.statusChan)
+			for event := range events {
+				if event.Type != EventRenderSucceeded {
+					continue
+				}
+				select {
+				case w.statusChan <- w.Status():
+				case <-w.stopChan:
+					return
+				}
+			}
+		}()
+	})
 	return w.statusChan
 }
 
-// ErrorChannel returns the channel for error updates
+// ErrorChannel returns a channel receiving the error of every render failed and error event.
+// It is backed by a subscription buffering the latest events, so an unread channel never
+// blocks the watcher and only the oldest errors are dropped. It is closed when the
+// watcher stops, dropping errors not yet received.
 func (w *Watcher) ErrorChannel() <-chan error {
+	w.errorOnce.Do(func() {
+		w.errorChan = make(chan error)
+		ctx, cancel := context.WithCancel(context.Background())
+		events := w.subscribe(ctx, legacyQueueSize)
+		go func() {
+			// Cancelling ends the subscription and discards its queue
+			defer cancel()
+			defer close(w.errorChan)
+			for event := range events {
+				if event.Err == nil {
+					continue
+				}
+				select {
+				case w.errorChan <- event.Err:
+				case <-w.stopChan:
+					return
+				}
+			}
+		}()
+	})
 	return w.errorChan
 }
 
-// watch monitors files for changes and triggers regeneration
+// batch is a set of coalesced changes ready to be processed
+type batch struct {
+	// jobs maps the keys of the jobs to regenerate to their changed files
+	jobs map[string][]string
+	// refresh re-expands the jobs because files were created, removed or renamed
+	refresh bool
+	// rebuild rebuilds the dependency graph because a template changed
+	rebuild bool
+}
+
+// watch collects file events and hands them to the processing goroutine once each
+// target has been quiet for the watch interval. A burst of events for the same target,
+// such as the write, chmod and rename of an editor's atomic save, regenerates it once.
 func (w *Watcher) watch() {
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+
+	due := make(map[string]time.Time)
+	changed := make(map[string][]string)
+	var refreshAt time.Time
+	rebuild := false
+	busy := false
+
+	// schedule arms the timer for the earliest pending deadline
+	schedule := func() {
+		var next time.Time
+		if !refreshAt.IsZero() {
+			next = refreshAt
+		}
+		for _, at := range due {
+			if next.IsZero() || at.Before(next) {
+				next = at
+			}
+		}
+		if !next.IsZero() && !busy {
+			timer.Reset(time.Until(next))
+		}
+	}
+
 	for {
 		select {
-		case event, ok := <-w.watcher.Events:
+		case event, ok := <-w.backend.Events():
 			if !ok {
 				return
 			}
 
-			// Check if the event is for our watched files
-			if w.isRelevantEvent(event) {
-				// Debounce changes
-				time.Sleep(w.interval)
+			deadline := time.Now().Add(w.interval)
+
+			// Templates may have appeared in or disappeared from a watched glob or directory.
+			// Re-expanding after the burst settles keeps a remove followed by a create from
+			// looking like a deleted template.
+			if event.Op&(fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
+				refreshAt = deadline
+			}
 
-				// Process the change
-				if err := w.processChange(); err != nil {
-					w.errorChan <- err
-				} else {
-					w.updateStatus()
+			if w.Graph().IsTemplate(event.Name) {
+				rebuild = true
+			}
+
+			// Trailing-edge debounce: every event pushes back its targets' deadlines
+			jobs := w.affectedJobs(event)
+			for _, job := range jobs {
+				key := jobKey(job)
+				due[key] = deadline
+				if !slices.Contains(changed[key], event.Name) {
+					changed[key] = append(changed[key], event.Name)
 				}
 			}
+			if len(jobs) > 0 {
+				w.publish(Event{Type: EventChangeDetected, Files: []string{event.Name}})
+			}
+			schedule()
 
-		case err, ok := <-w.watcher.Errors:
+		case err, ok := <-w.backend.Errors():
 			if !ok {
 				return
 			}
-			w.errorChan <- fmt.Errorf("watcher error: %w", err)
+			w.publishError(fmt.Errorf("watcher error: %w", err))
+
+		case <-timer.C:
+			now := time.Now()
+			b := batch{jobs: make(map[string][]string), rebuild: rebuild}
+			if !refreshAt.IsZero() && !now.Before(refreshAt) {
+				b.refresh = true
+				refreshAt = time.Time{}
+			}
+			for key, at := range due {
+				if !now.Before(at) {
+					b.jobs[key] = changed[key]
+					delete(due, key)
+					delete(changed, key)
+				}
+			}
+
+			if b.refresh || b.rebuild || len(b.jobs) > 0 {
+				rebuild = false
+				busy = true
+				select {
+				case w.work <- b:
+				case <-w.stopChan:
+					return
+				}
+			}
+			schedule()
+
+		case <-w.done:
+			// Changes that arrived while processing are now due
+			busy = false
+			schedule()
 
 		case <-w.stopChan:
 			return
@@ -136,59 +497,251 @@ func (w *Watcher) watch() {
 	}
 }
 
-// isRelevantEvent checks if the event is for our watched files
-func (w *Watcher) isRelevantEvent(event fsnotify.Event) bool {
-	// Check if the event is for our template or data file
-	return (event.Name == w.template || event.Name == w.data) &&
-		(event.Op&fsnotify.Write == fsnotify.Write || event.Op&fsnotify.Create == fsnotify.Create)
+// process regenerates the jobs of each batch handed over by watch
+func (w *Watcher) process() {
+	if w.preview != nil {
+		w.primePreview()
+	}
+
+	for {
+		select {
+		case b := <-w.work:
+			w.processBatch(b)
+			select {
+			case w.done <- struct{}{}:
+			case <-w.stopChan:
+				return
+			}
+
+		case <-w.stopChan:
+			return
+		}
+	}
 }
 
-// processChange handles file changes by regenerating the output
-func (w *Watcher) processChange() error {
-	// Load data if available
-	var data map[string]string
-	var err error
-	if w.data != "" {
-		data, err = engine.LoadData(w.data)
-		if err != nil {
-			return fmt.Errorf("error loading data: %w", err)
+// processBatch applies a batch of changes and regenerates its jobs in configuration order
+func (w *Watcher) processBatch(b batch) {
+	if b.refresh {
+		for _, job := range w.refresh() {
+			b.jobs[jobKey(job)] = job.Inputs()
+		}
+	}
+	if b.rebuild {
+		w.rebuildGraph()
+	}
+
+	// Re-add watches on directories replaced by atomic saves or newly created
+	if err := w.addWatches(); err != nil {
+		w.publishError(err)
+	}
+
+	// Jobs of the same target are regenerated together, running the target's hooks once
+	var jobs []project.Job
+	var changed []string
+	flush := func() {
+		if len(jobs) > 0 {
+			w.processChange(jobs, changed)
+		}
+		jobs, changed = nil, nil
+	}
+	for _, job := range w.currentJobs() {
+		files, due := b.jobs[jobKey(job)]
+		if !due {
+			continue
+		}
+		if len(jobs) > 0 && jobs[0].Target != job.Target {
+			flush()
+		}
+		jobs = append(jobs, job)
+		for _, file := range files {
+			if !slices.Contains(changed, file) {
+				changed = append(changed, file)
+			}
 		}
 	}
+	flush()
+}
 
-	// Render template
-	result, err := engine.RenderTemplate(w.template, data)
+// refresh re-expands the jobs after files were created or removed. It handles the
+// outputs of jobs whose template is gone and returns the jobs that appeared.
+func (w *Watcher) refresh() []project.Job {
+	jobs, err := w.expand()
 	if err != nil {
-		return fmt.Errorf("error rendering template: %w", err)
+		w.publishError(err)
+		return nil
 	}
 
-	// Write output
-	if err := engine.WriteToFile(w.output, result); err != nil {
-		return fmt.Errorf("error writing output: %w", err)
+	current := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		current[jobKey(job)] = true
+	}
+
+	previousJobs := w.currentJobs()
+	previous := make(map[string]bool, len(previousJobs))
+	for _, job := range previousJobs {
+		key := jobKey(job)
+		previous[key] = true
+		if current[key] {
+			continue
+		}
+
+		// Fall back to the configured output if the job never ran while watched
+		outputs, ran := w.outputs[key]
+		if !ran {
+			if info, err := os.Stat(job.Output); err == nil && !info.IsDir() {
+				outputs = []string{job.Output}
+			}
+		}
+		w.handleOrphans(job, outputs)
+		delete(w.outputs, key)
+		if w.preview != nil {
+			w.preview.Remove(previewSource(job))
+		}
+	}
+
+	var added []project.Job
+	for _, job := range jobs {
+		if !previous[jobKey(job)] {
+			added = append(added, job)
+		}
 	}
 
-	// Update last update time
 	w.mu.Lock()
-	w.lastUpdate = time.Now()
+	w.jobs = jobs
 	w.mu.Unlock()
+	w.rebuildGraph()
 
-	return nil
+	return added
 }
 
-// updateStatus sends the current status to the status channel
-func (w *Watcher) updateStatus() {
-	w.mu.Lock()
-	status := Status{
-		TemplatePath: w.template,
-		DataPath:     w.data,
-		OutputPath:   w.output,
-		LastUpdate:   w.lastUpdate,
-		IsWatching:   true,
+// affectedJobs returns the jobs reading the file the event is for, either as one of
+// their inputs or as a partial their template is rendered with. Every kind of event
+// counts, since editors save by writing, renaming over or replacing the file.
+func (w *Watcher) affectedJobs(event fsnotify.Event) []project.Job {
+	name := filepath.Clean(event.Name)
+	graph := w.Graph()
+
+	var jobs []project.Job
+	for _, job := range w.currentJobs() {
+		inputs := append(job.Inputs(), graph.Dependencies(job.Template)...)
+		for _, input := range inputs {
+			if filepath.Clean(input) == name {
+				jobs = append(jobs, job)
+				break
+			}
+		}
+	}
+	return jobs
+}
+
+// primePreview renders every job into the preview without writing outputs
+func (w *Watcher) primePreview() {
+	for _, job := range w.currentJobs() {
+		outputs, err := job.Render()
+		if err != nil {
+			w.preview.Fail(previewSource(job), err)
+			continue
+		}
+		w.preview.Update(previewSource(job), outputs)
+	}
+}
+
+// previewSource names a job in the preview's error overlay
+func previewSource(job project.Job) string {
+	return job.Target + ": " + job.Template
+}
+
+// processChange handles file changes by regenerating the outputs of jobs of the same
+// target, running the target's hooks once around them, and publishing the render events
+// of each job. A failing post_write or on_error hook is published as an error event.
+func (w *Watcher) processChange(jobs []project.Job, changed []string) {
+	for _, job := range jobs {
+		w.publish(Event{Type: EventRenderStarted, Target: job.Target, Template: job.Template, Files: changed})
+	}
+
+	run := project.RunTarget(jobs, changed)
+	if run.Jobs == nil {
+		// A pre_render hook failed, so no job ran
+		for _, job := range jobs {
+			w.jobFailed(job, run.HookErr)
+		}
+		return
+	}
+	for _, result := range run.Jobs {
+		if result.Err != nil {
+			w.jobFailed(result.Job, result.Err)
+			continue
+		}
+		w.jobSucceeded(result.Job, result.Outputs)
+	}
+
+	if run.HookErr != nil {
+		w.publishError(run.HookErr)
 	}
+}
+
+// jobFailed publishes the failure of a job
+func (w *Watcher) jobFailed(job project.Job, err error) {
+	if w.preview != nil {
+		w.preview.Fail(previewSource(job), err)
+	}
+	w.publish(Event{Type: EventRenderFailed, Target: job.Target, Template: job.Template, Err: err})
+}
+
+// jobSucceeded updates the preview and handles orphaned outputs after a job wrote outputs
+func (w *Watcher) jobSucceeded(job project.Job, outputs []engine.RenderedOutput) {
+	if w.preview != nil {
+		w.preview.Update(previewSource(job), outputs)
+	}
+
+	paths := make([]string, len(outputs))
+	for i, output := range outputs {
+		paths[i] = output.Path
+	}
+
+	// Outputs written last time but not this time are orphaned
+	key := jobKey(job)
+	written := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		written[path] = true
+	}
+	var orphaned []string
+	for _, path := range w.outputs[key] {
+		if !written[path] {
+			orphaned = append(orphaned, path)
+		}
+	}
+	w.outputs[key] = paths
+	w.handleOrphans(job, orphaned)
+
+	// Update last update time
+	w.mu.Lock()
+	w.lastJob = job
+	w.lastUpdate = time.Now()
 	w.mu.Unlock()
 
-	select {
-	case w.statusChan <- status:
-	default:
-		// Channel is full, skip update
+	w.publish(Event{Type: EventRenderSucceeded, Target: job.Target, Template: job.Template, Files: paths})
+}
+
+// handleOrphans reports or deletes outputs no longer generated from the job's template
+func (w *Watcher) handleOrphans(job project.Job, paths []string) {
+	for _, path := range paths {
+		if w.orphans != OrphanDelete {
+			w.publishError(&OrphanedOutputError{Path: path, Template: job.Template})
+			continue
+		}
+
+		if err := reliability.BackupFile(path); err != nil {
+			w.publishError(fmt.Errorf("error backing up orphaned output %s: %w", path, err))
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			w.publishError(fmt.Errorf("error removing orphaned output %s: %w", path, err))
+		}
 	}
 }
+
+// jobKey identifies a job across re-expansions
+func jobKey(job project.Job) string {
+	return job.Target + "\x00" + job.Template
+}